@@ -3,29 +3,38 @@ package app
 import (
 	"github.com/nilvxingren/echoxormdemo/ctx"
 	"github.com/nilvxingren/echoxormdemo/server"
-	"net/http"
 	"io/ioutil"
 	"github.com/BurntSushi/toml"
 	"strconv"
 	"os"
 	"errors"
+	"time"
 
+	"github.com/nilvxingren/echoxormdemo/keys"
 	"github.com/nilvxingren/echoxormdemo/logger"
+	"github.com/nilvxingren/echoxormdemo/mailer"
 	"github.com/go-xorm/xorm"
-	"github.com/nilvxingren/echoxormdemo/server/users"
+	"github.com/nilvxingren/echoxormdemo/migrations"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Application define a mode of running app
 type Application struct {
-	C *ctx.Context
+	C        *ctx.Context
+	warnings []string // problems of config found before logger is ready
 }
 
 // New constructor
 func New(flags *ctx.Flags) (*Application, error) {
 	app := new(Application)
 	app.C = new(ctx.Context)
-	// read config file
-	err := app.initConfigFromFile(flags.CfgFileName)
+	app.C.Flags = flags
+	// read config: file, environment, flags
+	err := app.initConfig(flags)
+	if err != nil {
+		return nil, err
+	}
+	err = app.checkBootstrap()
 	if err != nil {
 		return nil, err
 	}
@@ -35,6 +44,18 @@ func New(flags *ctx.Flags) (*Application, error) {
 	if err != nil {
 		return nil, err
 	}
+	for _, warning := range app.warnings {
+		app.C.Logger.Warn("config", warning)
+	}
+
+	// init Mailer
+	app.initMailer()
+
+	// init JWT keys
+	err = app.initKeys()
+	if err != nil {
+		return nil, err
+	}
 
 	// init Orm
 	err = app.initOrm()
@@ -48,16 +69,37 @@ func (a *Application) Run() {
 }
 
 
-// readConfig reads configuration file into application Config structure and inits in-memory token storage
-func (a *Application) initConfigFromFile(cfgFileName string) error {
-	// read config
-	tomlData, err := ioutil.ReadFile(cfgFileName)
-	if err != nil {
-		return errors.New("Configuration file read error: " + cfgFileName + "\nError:" + err.Error())
+// initConfig reads application Config in layers: configuration file (if any),
+// ECHOXORM_* environment variables, flags; defaults fill keys left empty
+func (a *Application) initConfig(flags *ctx.Flags) error {
+	a.C.Config = new(ctx.Config)
+	// read config file
+	if len(flags.CfgFileName) != 0 {
+		tomlData, err := ioutil.ReadFile(flags.CfgFileName)
+		if err != nil {
+			return errors.New("Configuration file read error: " + flags.CfgFileName + "\nError:" + err.Error())
+		}
+		md, err := toml.Decode(string(tomlData[:]), a.C.Config)
+		if err != nil {
+			return errors.New("Configuration file decoding error: " + flags.CfgFileName + "\nError:" + err.Error())
+		}
+		// misspelled keys would be ignored silently
+		for _, key := range md.Undecoded() {
+			a.warnings = append(a.warnings, "unknown key "+key.String()+" in "+flags.CfgFileName)
+		}
 	}
-	_, err = toml.Decode(string(tomlData[:]), &a.C.Config)
+	// override by environment and flags
+	err := a.C.Config.LoadEnv(os.LookupEnv)
 	if err != nil {
-		return errors.New("Configuration file decoding error: " + cfgFileName + "\nError:" + err.Error())
+		return errors.New("Configuration environment error: " + err.Error())
+	}
+	for name, value := range flags.Overrides {
+		if err = a.C.Config.Set(name, value); err != nil {
+			return errors.New("Configuration flag error: " + err.Error())
+		}
+	}
+	if len(a.C.Config.Mode) == 0 {
+		a.C.Config.Mode = ModeDevelopment
 	}
 	// init Logging data
 	if len(a.C.Config.Logging.ID) == 0 {
@@ -66,7 +108,57 @@ func (a *Application) initConfigFromFile(cfgFileName string) error {
 	if len(a.C.Config.Logging.LogTag) == 0 {
 		a.C.Config.Logging.LogTag = os.Args[0]
 	}
-	return nil
+	// init Auth data
+	if a.C.Config.Auth.MaxFailedLogins <= 0 {
+		a.C.Config.Auth.MaxFailedLogins = 5
+	}
+	if a.C.Config.Auth.LockoutTime.Duration <= 0 {
+		a.C.Config.Auth.LockoutTime.Duration = 15 * time.Minute
+	}
+	if a.C.Config.Auth.AccessTokenTTL.Duration <= 0 {
+		a.C.Config.Auth.AccessTokenTTL.Duration = 15 * time.Minute
+	}
+	if a.C.Config.Auth.RefreshTokenTTL.Duration <= 0 {
+		a.C.Config.Auth.RefreshTokenTTL.Duration = 30 * 24 * time.Hour
+	}
+	if a.C.Config.Auth.PasswordResetTTL.Duration <= 0 {
+		a.C.Config.Auth.PasswordResetTTL.Duration = time.Hour
+	}
+	// init RateLimit data, /auth routes are never left without limit
+	if a.C.Config.RateLimit.IPPerMinute <= 0 {
+		a.C.Config.RateLimit.IPPerMinute = 60
+	}
+	if a.C.Config.RateLimit.IPBurst <= 0 {
+		a.C.Config.RateLimit.IPBurst = 20
+	}
+	if a.C.Config.RateLimit.LoginPerMinute <= 0 {
+		a.C.Config.RateLimit.LoginPerMinute = 10
+	}
+	if a.C.Config.RateLimit.LoginBurst <= 0 {
+		a.C.Config.RateLimit.LoginBurst = 10
+	}
+	// init Users data, negative retention turns purge of deleted users off
+	if a.C.Config.Users.Retention.Duration == 0 {
+		a.C.Config.Users.Retention.Duration = 30 * 24 * time.Hour
+	}
+	if a.C.Config.Users.PurgeInterval.Duration <= 0 {
+		a.C.Config.Users.PurgeInterval.Duration = time.Hour
+	}
+	if a.C.Config.Users.ImportMaxRows <= 0 {
+		a.C.Config.Users.ImportMaxRows = 1000
+	}
+	if a.C.Config.Users.ImportBcryptCost <= 0 {
+		a.C.Config.Users.ImportBcryptCost = bcrypt.DefaultCost
+	}
+	// init MFA data
+	if len(a.C.Config.MFA.Issuer) == 0 {
+		a.C.Config.MFA.Issuer = "echo-xorm"
+	}
+	// init Mail data
+	if len(a.C.Config.Mail.From) == 0 {
+		a.C.Config.Mail.From = "noreply@localhost"
+	}
+	return a.C.Config.Validate()
 }
 
 // setupLogger sets apllication Logger up according to configuration settings
@@ -79,6 +171,33 @@ func (a *Application) initLogger() error {
 	return nil
 }
 
+// initMailer sets application Mailer up according to configuration settings
+func (a *Application) initMailer() {
+	mail := a.C.Config.Mail
+	switch mail.Mode {
+	case "smtp":
+		a.C.Mailer = mailer.NewSMTPMailer(mail.SMTPHost, mail.SMTPPort, mail.SMTPUsername, mail.SMTPPassword, mail.From)
+	case "file":
+		a.C.Mailer = mailer.NewFileMailer(mail.File, mail.From)
+	default:
+		a.C.Mailer = mailer.NewLogMailer(a.C.Logger, mail.From)
+	}
+}
+
+// initKeys loads JWT signing keys, HMAC key made of secret is used if there are no keys in config
+func (a *Application) initKeys() error {
+	var err error
+	if len(a.C.Config.JWT.Keys) == 0 {
+		a.C.Keys, err = keys.NewHMACKeySet(a.C.Config.Secret)
+	} else {
+		a.C.Keys, err = keys.Load(a.C.Config.JWT.Algorithm, a.C.Config.JWT.SigningKey, a.C.Config.JWT.Keys)
+	}
+	if err != nil {
+		return errors.New("JWT keys initialization error: " + err.Error())
+	}
+	return nil
+}
+
 // init database
 func (a *Application) initOrm() error {
 	var err error
@@ -91,33 +210,31 @@ func (a *Application) initOrm() error {
 	ormLogger := logger.NewOrmLogger(a.C.Logger)
 	a.C.Orm.SetLogger(ormLogger)
 	a.C.Orm.ShowSQL(true)
+	// commands manage database themselves
+	if len(a.C.Flags.Command) != 0 {
+		return nil
+	}
 	// migrate
-	//err = a.migrateDb()
-	//if err != nil {
-	//	return err
-	//}
-	//// init data
-	//err = a.initDbData()
-	return err
+	if a.C.Config.Database.AutoMigrate {
+		err = a.migrateDb()
+		if err != nil {
+			return err
+		}
+	}
+	// create initial admin
+	return a.bootstrapAdmin()
 }
 
-// migrate database
-func (a *Application) migrateDb() error {
-	var err error
-	// migrate tables
-	err = a.C.Orm.Sync(new(users.User))
-	return err
+// Migrator returns migrator of application database
+func (a *Application) Migrator() *migrations.Migrator {
+	return migrations.New(a.C.Orm, a.C.Logger, migrations.All)
 }
 
-// initDbData installs hardcoded data from config
-func (a *Application) initDbData() error {
-	user := &users.User{Login: "admin", Password: "admin"} // aaaa, backdoor
-	status, err := user.Save(a.C.Orm)
-	if err == nil {
-		return nil
-	}
-	if status == http.StatusConflict {
-		return nil
+// migrate database up to the latest version
+func (a *Application) migrateDb() error {
+	err := a.Migrator().Up()
+	if err != nil {
+		return errors.New("Database migration error: " + err.Error())
 	}
-	return err
+	return nil
 }
\ No newline at end of file
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/nilvxingren/echoxormdemo/server/access"
+	"github.com/nilvxingren/echoxormdemo/server/users"
+	"github.com/nilvxingren/echoxormdemo/utils"
+)
+
+// Modes of application
+const (
+	ModeDevelopment = "development"
+	ModeProduction  = "production"
+)
+
+// defaultAdminPassword is a password of well-known default credentials
+const defaultAdminPassword = "admin"
+
+// checkBootstrap refuses malformed password hash of initial admin, and its
+// default credentials in production
+func (a *Application) checkBootstrap() error {
+	cfg := a.C.Config.Bootstrap
+	if len(cfg.AdminLogin) == 0 {
+		return nil
+	}
+	// hash is stored as is, admin with malformed one could never log in
+	if len(cfg.AdminPasswordHash) != 0 {
+		if _, err := bcrypt.Cost([]byte(cfg.AdminPasswordHash)); err != nil {
+			return errors.New("Bootstrap error: admin_password_hash is not a bcrypt hash: " + err.Error())
+		}
+	}
+	if a.C.Config.Mode != ModeProduction {
+		return nil
+	}
+	weak := cfg.AdminPassword == defaultAdminPassword || (len(cfg.AdminPassword) != 0 && cfg.AdminPassword == cfg.AdminLogin)
+	if len(cfg.AdminPasswordHash) != 0 {
+		weak = bcrypt.CompareHashAndPassword([]byte(cfg.AdminPasswordHash), []byte(defaultAdminPassword)) == nil ||
+			bcrypt.CompareHashAndPassword([]byte(cfg.AdminPasswordHash), []byte(cfg.AdminLogin)) == nil
+	}
+	if weak {
+		return errors.New("Bootstrap error: default credentials of initial admin are refused in production mode")
+	}
+	return nil
+}
+
+// bootstrapAdmin creates initial admin from bootstrap config if there are no
+// admins yet. Admin gets password hash or password from config, otherwise
+// password is generated, printed once and has to be changed on first login
+func (a *Application) bootstrapAdmin() error {
+	cfg := a.C.Config.Bootstrap
+	if len(cfg.AdminLogin) == 0 {
+		return nil
+	}
+	// deleted admins count too, they may be restored
+	count, err := a.C.Orm.Unscoped().Where("role = ?", access.RoleAdmin).Count(&users.User{})
+	if err != nil {
+		return errors.New("Bootstrap error: " + err.Error())
+	}
+	if count != 0 {
+		return nil
+	}
+
+	user := &users.User{Login: cfg.AdminLogin, Role: access.RoleAdmin}
+	switch {
+	case len(cfg.AdminPasswordHash) != 0:
+		user.Password = cfg.AdminPasswordHash
+		err = user.CheckLogin(a.C.Orm)
+		if err == nil {
+			_, err = users.InsertBatch(a.C.Orm, []*users.User{user})
+		}
+	case len(cfg.AdminPassword) != 0:
+		user.Password = cfg.AdminPassword
+		err = user.Save(a.C.Orm)
+	default:
+		password, genErr := utils.GetRandomToken(12)
+		if genErr != nil {
+			return errors.New("Bootstrap error: " + genErr.Error())
+		}
+		user.Password = password
+		user.PasswordEtime = uint64(time.Now().UTC().Unix())
+		err = user.Save(a.C.Orm)
+		if err == nil {
+			// secret is not written to log
+			fmt.Fprintf(os.Stderr, "initial admin %q is created with password %q, it has to be changed on first login\n", cfg.AdminLogin, password)
+		}
+	}
+	if err != nil {
+		return errors.New("Bootstrap error: initial admin " + cfg.AdminLogin + ": " + err.Error())
+	}
+	a.C.Logger.Info("bootstrap", "initial admin "+cfg.AdminLogin+" created")
+	return nil
+}
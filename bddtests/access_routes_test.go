@@ -0,0 +1,50 @@
+package bddtests_test
+
+import (
+	"net/http"
+	"strconv"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/nilvxingren/echoxormdemo/server/access"
+	"github.com/nilvxingren/echoxormdemo/server/users"
+)
+
+var _ = Describe("Test access to /users", func() {
+	Context("with plain user token", func() {
+		It("should allow own record only", func() {
+			user := new(users.User)
+			payload := users.Input{Login: "a_test_access_user", Password: "a_test_access_user"}
+			resp, err := suite.rc.R().SetBody(payload).SetResult(user).Post("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusCreated))
+			Expect(user.Role).To(Equal(access.RoleUser))
+			rc := newAuthorizedClient(payload.Login, payload.Password)
+			id := strconv.FormatUint(user.ID, 10)
+
+			// own record
+			resp, err = rc.R().Get("/users/" + id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			// role escalation
+			resp, err = rc.R().SetBody(users.Input{Role: access.RoleAdmin}).Put("/users/" + id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusForbidden))
+			// someone else's record
+			resp, err = rc.R().Get("/users/1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusForbidden))
+			// admin only routes
+			resp, err = rc.R().Get("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusForbidden))
+			resp, err = rc.R().SetBody(users.Input{Login: "filler", Password: "filler"}).Post("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusForbidden))
+			resp, err = rc.R().Delete("/users/" + id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusForbidden))
+		})
+	})
+})
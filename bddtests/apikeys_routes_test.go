@@ -0,0 +1,101 @@
+package bddtests_test
+
+import (
+	"net/http"
+	"strconv"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/resty.v0"
+
+	"github.com/nilvxingren/echoxormdemo/server/access"
+	"github.com/nilvxingren/echoxormdemo/server/apikeys"
+	"github.com/nilvxingren/echoxormdemo/server/users"
+	"github.com/nilvxingren/echoxormdemo/validator"
+)
+
+var _ = Describe("Test /users/me/api-keys", func() {
+	Context("with read-only API key", func() {
+		It("should authorize reading only until revoked", func() {
+			result := new(apikeys.Result)
+			payload := apikeys.Input{Name: "batch job", Scopes: []string{access.ScopeUsersRead}}
+			resp, err := suite.rc.R().SetBody(payload).SetResult(result).Post("/users/me/api-keys")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusCreated))
+			Expect(result.Key).To(HavePrefix(result.Prefix))
+			// key is never shown again
+			var list []apikeys.APIKey
+			resp, err = suite.rc.R().SetResult(&list).Get("/users/me/api-keys")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(list).NotTo(BeEmpty())
+			Expect(resp.String()).NotTo(ContainSubstring(result.Key))
+
+			rc := resty.New().
+				SetHeader("Content-Type", "application/json").
+				SetHeader(apikeys.HeaderAPIKey, result.Key).
+				SetHostURL(suite.baseURL)
+			resp, err = rc.R().Get("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			resp, err = rc.R().SetBody(users.Input{Login: "filler", Password: "filler"}).Post("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusForbidden))
+			resp, err = rc.R().SetBody(payload).Post("/users/me/api-keys")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusForbidden))
+
+			// revoke
+			resp, err = suite.rc.R().Delete("/users/me/api-keys/" + strconv.FormatUint(result.ID, 10))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			resp, err = rc.R().Get("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Context("without scopes", func() {
+		It("should not be created", func() {
+			for _, body := range []string{`{"name":"root"}`, `{"name":"root","scopes":[]}`} {
+				resp, err := suite.rc.R().SetBody(body).Post("/users/me/api-keys")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode()).To(Equal(http.StatusBadRequest), body)
+				Expect(problemOf(resp).Errors).To(ConsistOf(
+					validator.FieldError{Field: "scopes", Rule: "required", Message: "is required"},
+				))
+			}
+		})
+	})
+
+	Context("with all sessions of user revoked", func() {
+		It("should not authorize anymore", func() {
+			user := new(users.User)
+			payload := users.Input{Login: "a_test_apikey_user", Password: "a_test_apikey_user"}
+			resp, err := suite.rc.R().SetBody(payload).SetResult(user).Post("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusCreated))
+			id := strconv.FormatUint(user.ID, 10)
+
+			result := new(apikeys.Result)
+			resp, err = newAuthorizedClient(payload.Login, payload.Password).R().
+				SetBody(apikeys.Input{Name: "leaked", Scopes: []string{access.ScopeUsersRead}}).SetResult(result).Post("/users/me/api-keys")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusCreated))
+			rc := resty.New().
+				SetHeader("Content-Type", "application/json").
+				SetHeader(apikeys.HeaderAPIKey, result.Key).
+				SetHostURL(suite.baseURL)
+			resp, err = rc.R().Get("/users/" + id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+
+			resp, err = suite.rc.R().Delete("/users/" + id + "/sessions")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			resp, err = rc.R().Get("/users/" + id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+		})
+	})
+})
@@ -0,0 +1,115 @@
+package bddtests_test
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/nilvxingren/echoxormdemo/server/audit"
+	"github.com/nilvxingren/echoxormdemo/server/auth"
+	"github.com/nilvxingren/echoxormdemo/server/users"
+	"github.com/nilvxingren/echoxormdemo/totp"
+)
+
+var _ = Describe("Test GET /audit", func() {
+	Context("after user changes and logins", func() {
+		It("should list entries with diffs and outcomes", func() {
+			user := new(users.User)
+			payload := users.Input{Login: "a_test_audit_user", Password: "a_test_audit_user"}
+			resp, err := suite.rc.R().SetBody(payload).SetResult(user).Post("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusCreated))
+			target := audit.UserTarget(user.ID)
+
+			payload.Email = "audit@example.com"
+			payload.Password = "a_test_audit_user_new"
+			resp, err = suite.rc.R().SetBody(payload).Put("/users/" + strconv.FormatUint(user.ID, 10))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+
+			resp, err = suite.rc.R().SetBody(auth.Input{Login: payload.Login, Password: "wrong-password"}).Post("/auth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+
+			// newest entries go first
+			result := new(audit.ListResult)
+			resp, err = suite.rc.R().SetResult(result).Get("/audit?target=" + target)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(result.Total).To(Equal(int64(3)))
+			Expect(result.Items).To(HaveLen(3))
+			login, update, create := result.Items[0], result.Items[1], result.Items[2]
+
+			Expect(login.Action).To(Equal(audit.ActionLogin))
+			Expect(login.Outcome).To(Equal(audit.OutcomeFailure))
+			Expect(login.Reason).To(Equal("invalid_credentials"))
+			Expect(login.IP).NotTo(BeEmpty())
+
+			Expect(update.Action).To(Equal(audit.ActionUserUpdate))
+			Expect(update.ActorID).NotTo(BeZero())
+			Expect(update.Changes).To(HaveKeyWithValue("email", audit.Change{Old: "", New: payload.Email}))
+			Expect(update.Changes).To(HaveKeyWithValue("password", audit.Change{Redacted: true}))
+
+			Expect(create.Action).To(Equal(audit.ActionUserCreate))
+			Expect(create.Changes).To(HaveKeyWithValue("login", audit.Change{Old: "", New: payload.Login}))
+
+			// filters and pages
+			result = new(audit.ListResult)
+			resp, err = suite.rc.R().SetResult(result).Get("/audit?limit=1&outcome=failure&target=" + target)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(result.Total).To(Equal(int64(1)))
+			Expect(result.Items[0].ID).To(Equal(login.ID))
+			resp, err = suite.rc.R().Get("/audit?limit=0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Context("after second factor and sessions changes", func() {
+		It("should list entries of them", func() {
+			user := new(users.User)
+			payload := users.Input{Login: "a_test_audit_mfa_user", Password: "a_test_audit_mfa_user"}
+			resp, err := suite.rc.R().SetBody(payload).SetResult(user).Post("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusCreated))
+			rc := newAuthorizedClient(payload.Login, payload.Password)
+
+			enroll := new(auth.TOTPEnrollment)
+			resp, err = rc.R().SetResult(enroll).Post("/users/me/totp")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			code, err := totp.Code(enroll.Secret, totp.Step(time.Now()))
+			Expect(err).NotTo(HaveOccurred())
+			wrong := code[:len(code)-1] + strconv.Itoa((int(code[len(code)-1]-'0')+1)%10)
+			resp, err = rc.R().SetBody(auth.TOTPInput{Code: wrong}).Post("/users/me/totp/confirm")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+			resp, err = rc.R().SetBody(auth.TOTPInput{Code: code}).Post("/users/me/totp/confirm")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+
+			resp, err = suite.rc.R().Delete("/users/" + strconv.FormatUint(user.ID, 10) + "/sessions")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+
+			result := new(audit.ListResult)
+			resp, err = suite.rc.R().SetResult(result).Get("/audit?limit=4&target=" + audit.UserTarget(user.ID))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(result.Items).To(HaveLen(4))
+			revoke, confirmed, refused, enrolled := result.Items[0], result.Items[1], result.Items[2], result.Items[3]
+			Expect(revoke.Action).To(Equal(audit.ActionSessionsRevoke))
+			Expect(revoke.ActorID).NotTo(Equal(user.ID))
+			Expect(confirmed.Action).To(Equal(audit.ActionTOTPConfirm))
+			Expect(confirmed.Outcome).To(Equal(audit.OutcomeSuccess))
+			Expect(confirmed.ActorID).To(Equal(user.ID))
+			Expect(refused.Action).To(Equal(audit.ActionTOTPConfirm))
+			Expect(refused.Outcome).To(Equal(audit.OutcomeFailure))
+			Expect(enrolled.Action).To(Equal(audit.ActionTOTPEnroll))
+		})
+	})
+})
@@ -0,0 +1,155 @@
+package bddtests_test
+
+import (
+	"net/http"
+	"strconv"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/resty.v0"
+
+	"github.com/nilvxingren/echoxormdemo/keys"
+	"github.com/nilvxingren/echoxormdemo/server/auth"
+	"github.com/nilvxingren/echoxormdemo/server/users"
+)
+
+var _ = Describe("Test POST /auth", func() {
+	Context("with valid credentials", func() {
+		It("should respond with token", func() {
+			result := new(auth.Result)
+			payload := auth.Input{Login: "admin", Password: "admin"}
+			resp, err := suite.rc.R().SetBody(payload).SetResult(result).Post("/auth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(result.Result).To(Equal("OK"))
+			Expect(result.Token).NotTo(BeEmpty())
+		})
+	})
+	Context("with wrong password or unknown login", func() {
+		It("should respond uniformly with 401", func() {
+			payload := auth.Input{Login: "admin", Password: "not-an-admin-password"}
+			resp, err := suite.rc.R().SetBody(payload).Post("/auth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+			wrongPasswordBody := resp.String()
+
+			payload = auth.Input{Login: "not-existing-login", Password: "admin"}
+			resp, err = suite.rc.R().SetBody(payload).Post("/auth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+			Expect(resp.String()).To(Equal(wrongPasswordBody))
+		})
+	})
+	Context("with too many failed attempts", func() {
+		It("should lock login out", func() {
+			payload := auth.Input{Login: "a_test_user_03", Password: "wrong-password"}
+			for i := 0; i < suite.app.C.Config.Auth.MaxFailedLogins; i++ {
+				resp, err := suite.rc.R().SetBody(payload).Post("/auth")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+			}
+			resp, err := suite.rc.R().SetBody(payload).Post("/auth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusTooManyRequests))
+			Expect(resp.Header().Get("Retry-After")).NotTo(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("Test POST /auth/refresh", func() {
+	Context("with rotated refresh token reused", func() {
+		It("should revoke whole token family", func() {
+			login := new(auth.Result)
+			resp, err := suite.rc.R().SetBody(auth.Input{Login: "admin", Password: "admin"}).SetResult(login).Post("/auth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(login.RefreshToken).NotTo(BeEmpty())
+			// rotate
+			rotated := new(auth.Result)
+			resp, err = suite.rc.R().SetBody(auth.RefreshInput{RefreshToken: login.RefreshToken}).SetResult(rotated).Post("/auth/refresh")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(rotated.Token).NotTo(BeEmpty())
+			Expect(rotated.RefreshToken).NotTo(Equal(login.RefreshToken))
+			// reuse rotated token
+			resp, err = suite.rc.R().SetBody(auth.RefreshInput{RefreshToken: login.RefreshToken}).Post("/auth/refresh")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+			// descendant token is revoked too
+			resp, err = suite.rc.R().SetBody(auth.RefreshInput{RefreshToken: rotated.RefreshToken}).Post("/auth/refresh")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+		})
+	})
+})
+
+var _ = Describe("Test POST /auth/logout", func() {
+	Context("with valid token", func() {
+		It("should revoke the token", func() {
+			rc := newAuthorizedClient("admin", "admin")
+			resp, err := rc.R().Get("/users/1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			// logout
+			resp, err = rc.R().SetBody(auth.RefreshInput{}).Post("/auth/logout")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			// token is revoked now
+			resp, err = rc.R().Get("/users/1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+		})
+	})
+})
+
+var _ = Describe("Test DELETE /users/:id/sessions", func() {
+	Context("with existing user", func() {
+		It("should revoke all tokens of the user", func() {
+			user := new(users.User)
+			payload := users.Input{Login: "a_test_sessions_user", Password: "a_test_sessions_user"}
+			resp, err := suite.rc.R().SetBody(payload).SetResult(user).Post("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusCreated))
+			rc := newAuthorizedClient(payload.Login, payload.Password)
+			// revoke
+			id := strconv.FormatUint(user.ID, 10)
+			resp, err = suite.rc.R().Delete("/users/" + id + "/sessions")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			resp, err = rc.R().Get("/users/" + id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+			// token issued right after revocation is valid
+			rc = newAuthorizedClient(payload.Login, payload.Password)
+			resp, err = rc.R().Get("/users/" + id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+		})
+	})
+})
+
+//------------------------------------------------------------------------------
+// newAuthorizedClient returns client with its own token, so that tests
+// revoking tokens do not affect suite client
+func newAuthorizedClient(login, password string) *resty.Client {
+	result := new(auth.Result)
+	rc := resty.New().
+		SetHeader("Content-Type", "application/json").
+		SetHostURL(suite.baseURL)
+	resp, err := rc.R().SetBody(auth.Input{Login: login, Password: password}).SetResult(result).Post("/auth")
+	Expect(err).NotTo(HaveOccurred())
+	Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+	return rc.SetAuthToken(result.Token)
+}
+
+var _ = Describe("Test GET /.well-known/jwks.json", func() {
+	Context("with HMAC key set", func() {
+		It("should not publish symmetric keys", func() {
+			result := new(keys.JWKS)
+			resp, err := suite.rc.R().SetResult(result).Get("/.well-known/jwks.json")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(result.Keys).To(BeEmpty())
+		})
+	})
+})
@@ -33,6 +33,9 @@ var _ = BeforeSuite(func() {
 })
 
 var _ = AfterSuite(func() {
+	if suite.smtp != nil {
+		suite.smtp.Close()
+	}
 	if suite.app.C.Orm != nil {
 		suite.app.C.Orm.Close()
 	}
@@ -48,6 +51,7 @@ type LsxTestSuite struct {
 	app     *app.Application
 	baseURL string
 	rc      *resty.Client
+	smtp    *smtpStandIn
 }
 
 // SetupTest called once before test
@@ -57,6 +61,11 @@ func (s *LsxTestSuite) setupSuite() error {
 		return err
 	}
 	s.baseURL = "http://localhost:" + s.app.C.Config.Port
+	// catch mail sent by app
+	s.smtp, err = startSMTPStandIn("localhost:" + s.app.C.Config.Mail.SMTPPort)
+	if err != nil {
+		return err
+	}
 	// create and setup resty client
 	s.rc = resty.DefaultClient
 	s.rc.SetHeader("Content-Type", "application/json")
@@ -0,0 +1,132 @@
+package bddtests_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/nilvxingren/echoxormdemo/app"
+	"github.com/nilvxingren/echoxormdemo/ctx"
+	"github.com/nilvxingren/echoxormdemo/server/access"
+	"github.com/nilvxingren/echoxormdemo/server/auth"
+	"github.com/nilvxingren/echoxormdemo/server/users"
+)
+
+var _ = Describe("Test bootstrap admin", func() {
+	Context("in production mode", func() {
+		It("should refuse default credentials", func() {
+			data, err := ioutil.ReadFile(cfgFileName)
+			Expect(err).NotTo(HaveOccurred())
+			cfg := strings.Replace(string(data), `mode = "development"`, `mode = "production"`, 1)
+			file, err := ioutil.TempFile("", "echo-xorm-config")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.Remove(file.Name())
+			_, err = file.WriteString(cfg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(file.Close()).To(Succeed())
+
+			_, err = app.New(&ctx.Flags{CfgFileName: file.Name()})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("default credentials"))
+		})
+	})
+
+	Context("with password hash", func() {
+		It("should store hash as is and skip bootstrap once admin exists", func() {
+			dsn := tempDatabase()
+			defer os.Remove(dsn)
+			hash, err := bcrypt.GenerateFromPassword([]byte("a_test_root_pass1"), bcrypt.MinCost)
+			Expect(err).NotTo(HaveOccurred())
+
+			a, err := newBootstrapApp(dsn, "a_test_root", "", string(hash))
+			Expect(err).NotTo(HaveOccurred())
+			defer a.C.Orm.Close()
+			admin := users.User{Login: "a_test_root"}
+			Expect(admin.Find(a.C.Orm)).To(Succeed())
+			Expect(admin.Role).To(Equal(access.RoleAdmin))
+			Expect(admin.Password).To(Equal(string(hash)))
+			result := bootstrapLogin(a, "a_test_root", "a_test_root_pass1")
+			Expect(result.Result).To(Equal("OK"))
+
+			// admin exists, the other one is not created
+			another, err := newBootstrapApp(dsn, "a_test_root2", "a_test_root2_pass1", "")
+			Expect(err).NotTo(HaveOccurred())
+			defer another.C.Orm.Close()
+			count, err := another.C.Orm.Where("role = ?", access.RoleAdmin).Count(&users.User{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(BeEquivalentTo(1))
+			Expect((&users.User{Login: "a_test_root2"}).Find(another.C.Orm)).To(Equal(users.ErrNotFound))
+		})
+
+		It("should refuse malformed hash", func() {
+			dsn := tempDatabase()
+			defer os.Remove(dsn)
+			_, err := newBootstrapApp(dsn, "a_test_root", "", "$2a$10$not-a-hash")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("admin_password_hash"))
+		})
+	})
+
+	Context("without password", func() {
+		It("should print generated password to be changed on first login", func() {
+			dsn := tempDatabase()
+			defer os.Remove(dsn)
+			r, w, err := os.Pipe()
+			Expect(err).NotTo(HaveOccurred())
+			stderr := os.Stderr
+			os.Stderr = w
+			a, err := newBootstrapApp(dsn, "a_test_root", "", "")
+			os.Stderr = stderr
+			Expect(w.Close()).To(Succeed())
+			Expect(err).NotTo(HaveOccurred())
+			defer a.C.Orm.Close()
+			printed, err := ioutil.ReadAll(r)
+			Expect(err).NotTo(HaveOccurred())
+			password := regexp.MustCompile(`with password "([^"]+)"`).FindStringSubmatch(string(printed))
+			Expect(password).To(HaveLen(2))
+
+			admin := users.User{Login: "a_test_root"}
+			Expect(admin.Find(a.C.Orm)).To(Succeed())
+			Expect(admin.PasswordEtime).NotTo(BeZero())
+			result := bootstrapLogin(a, "a_test_root", password[1])
+			Expect(result.Result).To(Equal("PASSWORD_EXPIRED"))
+			Expect(result.RefreshToken).To(BeEmpty())
+		})
+	})
+})
+
+//------------------------------------------------------------------------------
+// tempDatabase returns name of new empty sqlite database file
+func tempDatabase() string {
+	file, err := ioutil.TempFile("", "echo-xorm-bootstrap")
+	Expect(err).NotTo(HaveOccurred())
+	Expect(file.Close()).To(Succeed())
+	return file.Name()
+}
+
+// newBootstrapApp starts application of test config on database dsn with bootstrap keys given
+func newBootstrapApp(dsn, login, password, hash string) (*app.Application, error) {
+	return app.New(&ctx.Flags{CfgFileName: cfgFileName, Overrides: map[string]string{
+		"database.dsn":                  dsn,
+		"bootstrap.admin_login":         login,
+		"bootstrap.admin_password":      password,
+		"bootstrap.admin_password_hash": hash,
+	}})
+}
+
+// bootstrapLogin logs in to application a
+func bootstrapLogin(a *app.Application, login, password string) *auth.Result {
+	rc, done := newAuthClient(a.C, auth.NewRateLimiter(600, 100), auth.NewRateLimiter(600, 100))
+	defer done()
+	result := new(auth.Result)
+	resp, err := rc.R().SetBody(auth.Input{Login: login, Password: password}).SetResult(result).Post("/auth")
+	Expect(err).NotTo(HaveOccurred())
+	Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+	return result
+}
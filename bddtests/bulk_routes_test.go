@@ -0,0 +1,105 @@
+package bddtests_test
+
+import (
+	"net/http"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/nilvxingren/echoxormdemo/server/users"
+)
+
+var _ = Describe("Test POST /users/import", func() {
+	Context("with CSV in dry run", func() {
+		It("should report rows without saving", func() {
+			result := new(users.ImportResult)
+			body := "login,email,password\n" +
+				"a_test_import_csv,csv@example.com,a_test_import_csv\n" +
+				"a_test_import_nopass,,\n" +
+				"admin,,admin\n"
+			resp, err := suite.rc.R().SetHeader("Content-Type", "text/csv").SetBody(body).SetResult(result).
+				Post("/users/import?dry_run=true")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(result.DryRun).To(BeTrue())
+			Expect(result.Total).To(Equal(3))
+			Expect(result.Failed).To(Equal(2))
+			Expect(result.Created).To(BeZero())
+			Expect(result.Rows[0].Errors).To(BeEmpty())
+			Expect(result.Rows[1].Errors).To(ContainElement("password is required"))
+			Expect(result.Rows[2].Errors).NotTo(BeEmpty())
+
+			count, err := suite.app.C.Orm.Where("login = ?", "a_test_import_csv").Count(&users.User{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(BeZero())
+		})
+	})
+
+	Context("with JSON Lines", func() {
+		It("should create users", func() {
+			result := new(users.ImportResult)
+			body := `{"login":"a_test_import_01","password":"a_test_import_01"}` + "\n" +
+				`{"login":"a_test_import_02","password":"a_test_import_02","role":"user"}` + "\n"
+			resp, err := suite.rc.R().SetHeader("Content-Type", "application/x-ndjson").SetBody(body).SetResult(result).
+				Post("/users/import")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(result.Created).To(Equal(2))
+			Expect(result.Rows[0].ID).NotTo(BeZero())
+			newAuthorizedClient("a_test_import_02", "a_test_import_02")
+			// hashed with cost of import
+			user := users.User{ID: result.Rows[0].ID}
+			Expect(user.Find(suite.app.C.Orm)).To(Succeed())
+			Expect(bcrypt.Cost([]byte(user.Password))).To(Equal(suite.app.C.Config.Users.ImportBcryptCost))
+		})
+	})
+
+	Context("with more rows than limit", func() {
+		It("should respond with 413 before checking rows", func() {
+			limit := suite.app.C.Config.Users.ImportMaxRows
+			suite.app.C.Config.Users.ImportMaxRows = 2
+			defer func() { suite.app.C.Config.Users.ImportMaxRows = limit }()
+
+			body := "login,password\na_test_import_03,x\na_test_import_04,x\na_test_import_05,x\n"
+			resp, err := suite.rc.R().SetHeader("Content-Type", "text/csv").SetBody(body).Post("/users/import")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusRequestEntityTooLarge))
+			Expect(problemOf(resp).Detail).To(Equal("import is limited to 2 rows"))
+		})
+	})
+
+	Context("with unknown format", func() {
+		It("should respond with 415", func() {
+			resp, err := suite.rc.R().SetHeader("Content-Type", "text/plain").SetBody("login").Post("/users/import")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnsupportedMediaType))
+		})
+	})
+})
+
+var _ = Describe("Test GET /users/export", func() {
+	Context("as JSON Lines and CSV", func() {
+		It("should stream all users without passwords", func() {
+			count, err := suite.app.C.Orm.Count(&users.User{})
+			Expect(err).NotTo(HaveOccurred())
+
+			resp, err := suite.rc.R().Get("/users/export")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			lines := strings.Split(strings.TrimSpace(resp.String()), "\n")
+			Expect(lines).To(HaveLen(int(count)))
+			Expect(resp.String()).NotTo(ContainSubstring("password\":"))
+			Expect(resp.String()).NotTo(ContainSubstring("$2a$"))
+
+			resp, err = suite.rc.R().Get("/users/export?format=csv")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			lines = strings.Split(strings.TrimSpace(resp.String()), "\n")
+			Expect(lines).To(HaveLen(int(count) + 1))
+			Expect(lines[0]).To(Equal("id,login,email,role,totp_enabled,password_etime,version,created,updated"))
+			Expect(resp.String()).NotTo(ContainSubstring("$2a$"))
+		})
+	})
+})
@@ -0,0 +1,118 @@
+package bddtests_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/resty.v0"
+
+	"github.com/nilvxingren/echoxormdemo/cli"
+	"github.com/nilvxingren/echoxormdemo/server/auth"
+	"github.com/nilvxingren/echoxormdemo/server/users"
+)
+
+var _ = Describe("Test commands", func() {
+	Context("user create, set-password and disable", func() {
+		It("should manage user without HTTP API", func() {
+			login := "a_test_cli_user"
+			Expect(run("user", "create", "--login", login, "--password", "a_test_cli_pass1")).To(Succeed())
+			Expect(run("user", "create", "--login", login, "--password", "a_test_cli_pass1")).NotTo(Succeed())
+			Expect(run("user", "create", "--login", "a_test_cli_weak", "--password", "weak")).NotTo(Succeed())
+
+			resp, err := suite.rc.R().SetBody(auth.Input{Login: login, Password: "a_test_cli_pass1"}).Post("/auth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+
+			Expect(run("user", "set-password", "--login", login, "--password", "a_test_cli_pass2")).To(Succeed())
+			resp, err = suite.rc.R().SetBody(auth.Input{Login: login, Password: "a_test_cli_pass2"}).Post("/auth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+
+			Expect(run("user", "disable", "--login", login)).To(Succeed())
+			resp, err = suite.rc.R().SetBody(auth.Input{Login: login, Password: "a_test_cli_pass2"}).Post("/auth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+
+			Expect(run("token", "issue", "--user", login)).NotTo(Succeed())
+			Expect(run("user", "bogus")).NotTo(Succeed())
+		})
+	})
+
+	Context("user list", func() {
+		It("should print users", func() {
+			out, err := runOutput("user", "list")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out).To(HavePrefix("ID"))
+			Expect(out).To(MatchRegexp(`(?m)^1\s+admin\s+`))
+		})
+	})
+
+	Context("token issue", func() {
+		It("should print access token of user", func() {
+			out, err := runOutput("token", "issue", "--user", "a_test_user_02")
+			Expect(err).NotTo(HaveOccurred())
+			token := strings.TrimSpace(out)
+			Expect(token).NotTo(BeEmpty())
+
+			me := new(users.User)
+			rc := resty.New().SetHeader("Content-Type", "application/json").SetHostURL(suite.baseURL).SetAuthToken(token)
+			resp, err := rc.R().SetResult(me).Get("/users/me")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(me.Login).To(Equal("a_test_user_02"))
+
+			Expect(run("token", "issue", "--user", "a_test_cli_nobody")).NotTo(Succeed())
+			Expect(run("token", "issue")).NotTo(Succeed())
+		})
+	})
+
+	Context("config print", func() {
+		It("should print configuration with secrets redacted", func() {
+			out, err := runOutput("config", "print")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out).To(ContainSubstring("smtp_port = \"11125\""))
+			Expect(out).To(ContainSubstring("secret = \"******\""))
+			Expect(out).To(ContainSubstring("dsn = \"******\""))
+			Expect(out).NotTo(ContainSubstring("jwt-super-secret"))
+			Expect(out).NotTo(ContainSubstring("echo-xorm-test.sqlite.db"))
+			Expect(out).NotTo(MatchRegexp(`admin_password = "admin"`))
+		})
+	})
+
+	Context("migrate status", func() {
+		It("should print all migrations applied", func() {
+			out, err := runOutput("migrate", "status")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out).To(MatchRegexp(`^\s+1\s+`))
+			Expect(out).NotTo(ContainSubstring("pending"))
+		})
+	})
+})
+
+//------------------------------------------------------------------------------
+// run runs command with test config
+func run(args ...string) error {
+	return cli.Run(append([]string{"-config=" + cfgFileName}, args...))
+}
+
+// runOutput runs command with test config and returns what it printed to stdout
+func runOutput(args ...string) (string, error) {
+	r, w, err := os.Pipe()
+	Expect(err).NotTo(HaveOccurred())
+	stdout := os.Stdout
+	os.Stdout = w
+	// pipe is read concurrently, output may exceed its buffer
+	printed := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		printed <- data
+	}()
+	err = run(args...)
+	os.Stdout = stdout
+	Expect(w.Close()).To(Succeed())
+	return string(<-printed), err
+}
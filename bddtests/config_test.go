@@ -0,0 +1,63 @@
+package bddtests_test
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/nilvxingren/echoxormdemo/ctx"
+)
+
+var _ = Describe("Test config layers", func() {
+	Context("with environment and flags", func() {
+		It("should override file values", func() {
+			secret, err := ioutil.TempFile("", "echo-xorm-secret")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.Remove(secret.Name())
+			_, err = secret.WriteString("secret-from-file\n")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(secret.Close()).To(Succeed())
+
+			env := map[string]string{
+				"ECHOXORM_PORT":                   "12345",
+				"ECHOXORM_AUTH_MAX_FAILED_LOGINS": "7",
+				"ECHOXORM_AUTH_LOCKOUT_TIME":      "2m",
+				"ECHOXORM_DATABASE_AUTO_MIGRATE":  "false",
+				"ECHOXORM_SECRET_FILE":            secret.Name(),
+			}
+			lookup := func(name string) (string, bool) {
+				value, ok := env[name]
+				return value, ok
+			}
+			cfg := &ctx.Config{Port: "11116", Secret: "secret-from-toml"}
+			cfg.Database.AutoMigrate = true
+			Expect(cfg.LoadEnv(lookup)).To(Succeed())
+			Expect(cfg.Port).To(Equal("12345"))
+			Expect(cfg.Auth.MaxFailedLogins).To(Equal(7))
+			Expect(cfg.Auth.LockoutTime.Duration).To(Equal(2 * time.Minute))
+			Expect(cfg.Database.AutoMigrate).To(BeFalse())
+			Expect(cfg.Secret).To(Equal("secret-from-file"))
+
+			Expect(cfg.Set("port", "23456")).To(Succeed())
+			Expect(cfg.Port).To(Equal("23456"))
+			Expect(cfg.Set("no.such_key", "1")).NotTo(Succeed())
+			Expect(cfg.Set("auth.max_failed_logins", "many")).NotTo(Succeed())
+
+			env["ECHOXORM_AUTH_LOCKOUT_TIME"] = "soon"
+			Expect(cfg.LoadEnv(lookup)).NotTo(Succeed())
+		})
+
+		It("should redact secrets", func() {
+			cfg := &ctx.Config{Secret: "jwt-super-secret", Port: "11116"}
+			cfg.Database.Dsn = "root:password@/db"
+			printed := cfg.Redacted()
+			Expect(printed.Secret).NotTo(ContainSubstring("super"))
+			Expect(printed.Database.Dsn).NotTo(ContainSubstring("password"))
+			Expect(printed.Port).To(Equal("11116"))
+			Expect(cfg.Secret).To(Equal("jwt-super-secret"))
+		})
+	})
+})
@@ -0,0 +1,80 @@
+package bddtests_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/nilvxingren/echoxormdemo/app"
+	"github.com/nilvxingren/echoxormdemo/ctx"
+)
+
+var _ = Describe("Test config validation", func() {
+	Context("with invalid values", func() {
+		It("should report every problem at once", func() {
+			cfg := &ctx.Config{Secret: "short", Port: "x", Mode: "prod"}
+			cfg.Database.Db = "oracle"
+			cfg.Logging.LogMode = "fancy"
+			err := cfg.Validate()
+			Expect(err).To(HaveOccurred())
+			errs, ok := err.(ctx.ConfigErrors)
+			Expect(ok).To(BeTrue())
+			Expect(errs).To(HaveLen(6))
+			Expect(err.Error()).To(ContainSubstring("secret must be at least"))
+			Expect(err.Error()).To(ContainSubstring("port must be a number"))
+			Expect(err.Error()).To(ContainSubstring("database.db must be one of"))
+			Expect(err.Error()).To(ContainSubstring("logging.log_mode must be one of"))
+		})
+
+		It("should refuse log modes without logger", func() {
+			for _, mode := range []string{"fluent", "fluentd"} {
+				cfg := &ctx.Config{Secret: "jwt-super-secret", Port: "11116", Mode: ctx.Modes[0]}
+				cfg.Database.Db = "sqlite3"
+				cfg.Logging.LogMode = mode
+				err := cfg.Validate()
+				Expect(err).To(HaveOccurred(), mode)
+				Expect(err.Error()).To(ContainSubstring("logging.log_mode must be one of"))
+			}
+		})
+
+		It("should refuse mail to log in production", func() {
+			for _, mode := range []string{"", "log"} {
+				cfg := &ctx.Config{Secret: "jwt-super-secret", Port: "11116", Mode: "production"}
+				cfg.Database.Db = "sqlite3"
+				cfg.Database.Dsn = "./test.db"
+				cfg.Mail.Mode = mode
+				err := cfg.Validate()
+				Expect(err).To(HaveOccurred(), mode)
+				Expect(err.Error()).To(ContainSubstring("refused in production mode"))
+			}
+			cfg := &ctx.Config{Secret: "jwt-super-secret", Port: "11116", Mode: "production"}
+			cfg.Database.Db = "sqlite3"
+			cfg.Database.Dsn = "./test.db"
+			cfg.Mail.Mode = "smtp"
+			Expect(cfg.Validate()).To(Succeed())
+		})
+
+		It("should accept valid config", func() {
+			cfg := &ctx.Config{Secret: "jwt-super-secret", Port: "11116", Mode: ctx.Modes[0]}
+			cfg.Database.Db = "sqlite3"
+			cfg.Database.Dsn = "./test.db"
+			Expect(cfg.Validate()).To(Succeed())
+		})
+
+		It("should refuse to start", func() {
+			file, err := ioutil.TempFile("", "echo-xorm-config")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.Remove(file.Name())
+			_, err = file.WriteString("secret = \"short\"\nport = \"70000\"\n[database]\ndb = \"sqlite3\"\ndsn = \"./test.db\"\n")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(file.Close()).To(Succeed())
+
+			_, err = app.New(&ctx.Flags{CfgFileName: file.Name(), Command: "config"})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("secret must be at least"))
+			Expect(err.Error()).To(ContainSubstring("port must be a number"))
+		})
+	})
+})
@@ -0,0 +1,95 @@
+package bddtests_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/nilvxingren/echoxormdemo/server/auth"
+	"github.com/nilvxingren/echoxormdemo/server/groups"
+	"github.com/nilvxingren/echoxormdemo/server/users"
+)
+
+var _ = Describe("Test /groups", func() {
+	Context("with members", func() {
+		It("should manage group and membership", func() {
+			group := new(groups.Group)
+			resp, err := suite.rc.R().SetBody(groups.Input{Name: "a_test_group", Description: "testers"}).SetResult(group).Post("/groups")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusCreated))
+			Expect(group.Name).To(Equal("a_test_group"))
+			path := "/groups/" + strconv.FormatUint(group.ID, 10)
+			// names are unique
+			resp, err = suite.rc.R().SetBody(groups.Input{Name: "a_test_group"}).Post("/groups")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusConflict))
+
+			// add member
+			user := new(users.User)
+			payload := users.Input{Login: "a_test_group_member", Password: "a_test_group_member"}
+			resp, err = suite.rc.R().SetBody(payload).SetResult(user).Post("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusCreated))
+			resp, err = suite.rc.R().SetBody(groups.MemberInput{UserID: user.ID}).Post(path + "/members")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusCreated))
+			resp, err = suite.rc.R().SetBody(groups.MemberInput{UserID: user.ID}).Post(path + "/members")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusConflict))
+			var members []groups.Member
+			resp, err = suite.rc.R().SetResult(&members).Get(path + "/members")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(members).To(HaveLen(1))
+			Expect(members[0].Login).To(Equal(payload.Login))
+
+			// groups of user
+			userPath := "/users/" + strconv.FormatUint(user.ID, 10)
+			found := new(users.User)
+			resp, err = suite.rc.R().SetResult(found).Get(userPath + "?include=groups")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(found.Groups).To(HaveLen(1))
+			Expect(found.Groups[0].Name).To(Equal("a_test_group"))
+			found = new(users.User)
+			resp, err = suite.rc.R().SetResult(found).Get(userPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found.Groups).To(BeEmpty())
+
+			// group names in token
+			login := new(auth.Result)
+			resp, err = suite.rc.R().SetBody(auth.Input{Login: payload.Login, Password: payload.Password}).SetResult(login).Post("/auth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(tokenClaims(login.Token)["groups"]).To(ConsistOf("a_test_group"))
+
+			// remove member, delete group
+			resp, err = suite.rc.R().Delete(path + "/members/" + strconv.FormatUint(user.ID, 10))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			resp, err = suite.rc.R().Delete(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			resp, err = suite.rc.R().Get(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusNotFound))
+		})
+	})
+})
+
+//------------------------------------------------------------------------------
+// tokenClaims decodes claims of JWT without verification
+func tokenClaims(token string) map[string]interface{} {
+	parts := strings.Split(token, ".")
+	Expect(parts).To(HaveLen(3))
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	Expect(err).NotTo(HaveOccurred())
+	claims := map[string]interface{}{}
+	Expect(json.Unmarshal(payload, &claims)).To(Succeed())
+	return claims
+}
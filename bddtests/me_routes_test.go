@@ -0,0 +1,68 @@
+package bddtests_test
+
+import (
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/resty.v0"
+
+	"github.com/nilvxingren/echoxormdemo/server/auth"
+	"github.com/nilvxingren/echoxormdemo/server/users"
+)
+
+var _ = Describe("Test /users/me", func() {
+	Context("with token of user", func() {
+		It("should read, update and delete own account", func() {
+			user := new(users.User)
+			payload := users.Input{Login: "a_test_me_user", Password: "a_test_me_user"}
+			resp, err := suite.rc.R().SetBody(payload).SetResult(user).Post("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusCreated))
+
+			login := new(auth.Result)
+			rc := resty.New().SetHeader("Content-Type", "application/json").SetHostURL(suite.baseURL)
+			resp, err = rc.R().SetBody(auth.Input{Login: payload.Login, Password: payload.Password}).SetResult(login).Post("/auth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			rc.SetAuthToken(login.Token)
+
+			// read
+			me := new(users.User)
+			resp, err = rc.R().SetResult(me).Get("/users/me")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(me.ID).To(Equal(user.ID))
+			Expect(me.Login).To(Equal(payload.Login))
+			Expect(resp.Header().Get("ETag")).NotTo(BeEmpty())
+
+			// update login and email, role is kept
+			profile := users.ProfileInput{Login: "a_test_me_renamed", Email: "me@example.com"}
+			resp, err = rc.R().SetBody(profile).SetResult(me).Put("/users/me")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(me.Login).To(Equal(profile.Login))
+			Expect(me.Email).To(Equal(profile.Email))
+			Expect(me.Role).To(Equal(user.Role))
+			resp, err = rc.R().SetBody(users.ProfileInput{Login: "admin"}).Put("/users/me")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusConflict))
+
+			// delete requires password
+			resp, err = rc.R().SetBody(auth.DeleteMeInput{Password: "wrong-password"}).Delete("/users/me")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusForbidden))
+			resp, err = rc.R().SetBody(auth.DeleteMeInput{Password: payload.Password}).Delete("/users/me")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+
+			// sessions are revoked
+			resp, err = rc.R().Get("/users/me")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+			resp, err = rc.R().SetBody(auth.RefreshInput{RefreshToken: login.RefreshToken}).Post("/auth/refresh")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+		})
+	})
+})
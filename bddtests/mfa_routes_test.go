@@ -0,0 +1,62 @@
+package bddtests_test
+
+import (
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/nilvxingren/echoxormdemo/server/auth"
+	"github.com/nilvxingren/echoxormdemo/server/users"
+	"github.com/nilvxingren/echoxormdemo/totp"
+)
+
+var _ = Describe("Test TOTP two-factor authentication", func() {
+	Context("with enrolled user", func() {
+		It("should require the second step of login", func() {
+			payload := users.Input{Login: "a_test_mfa_user", Password: "a_test_mfa_user"}
+			resp, err := suite.rc.R().SetBody(payload).Post("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusCreated))
+			rc := newAuthorizedClient(payload.Login, payload.Password)
+
+			// enroll
+			enroll := new(auth.TOTPEnrollment)
+			resp, err = rc.R().SetResult(enroll).Post("/users/me/totp")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(enroll.URI).To(HavePrefix("otpauth://totp/"))
+			Expect(enroll.RecoveryCodes).NotTo(BeEmpty())
+			// confirm
+			code, err := totp.Code(enroll.Secret, totp.Step(time.Now()))
+			Expect(err).NotTo(HaveOccurred())
+			resp, err = rc.R().SetBody(auth.TOTPInput{Code: code}).Post("/users/me/totp/confirm")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+
+			// the first step gives token of no use but the second step
+			first := new(auth.Result)
+			resp, err = suite.rc.R().SetBody(auth.Input{Login: payload.Login, Password: payload.Password}).SetResult(first).Post("/auth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(first.Result).To(Equal("MFA_REQUIRED"))
+			Expect(first.RefreshToken).To(BeEmpty())
+			// code already used for confirmation is refused
+			resp, err = suite.rc.R().SetBody(auth.TOTPInput{MFAToken: first.Token, Code: code}).Post("/auth/totp")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+			// recovery code works once
+			second := new(auth.Result)
+			recovery := auth.TOTPInput{MFAToken: first.Token, Code: enroll.RecoveryCodes[0]}
+			resp, err = suite.rc.R().SetBody(recovery).SetResult(second).Post("/auth/totp")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(second.Result).To(Equal("OK"))
+			Expect(second.RefreshToken).NotTo(BeEmpty())
+			resp, err = suite.rc.R().SetBody(recovery).Post("/auth/totp")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+		})
+	})
+})
@@ -0,0 +1,137 @@
+package bddtests_test
+
+import (
+	"os"
+	"time"
+
+	"github.com/go-xorm/xorm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/nilvxingren/echoxormdemo/migrations"
+	"github.com/nilvxingren/echoxormdemo/server/access"
+	"github.com/nilvxingren/echoxormdemo/server/users"
+)
+
+var _ = Describe("Test migrations", func() {
+	Context("on started application", func() {
+		It("should have every migration applied", func() {
+			status, err := suite.app.Migrator().Status()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status).To(HaveLen(len(migrations.All)))
+			for _, s := range status {
+				Expect(s.Applied).NotTo(BeZero(), s.Name)
+			}
+		})
+
+		It("should revert and apply the last migration", func() {
+			m := suite.app.Migrator()
+			last := migrations.All[len(migrations.All)-1]
+			Expect(m.Down()).To(Succeed())
+			status, err := m.Status()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status[len(status)-1].Applied).To(BeZero())
+			Expect(status[len(status)-2].Applied).NotTo(BeZero())
+
+			Expect(m.To(last.Version)).To(Succeed())
+			status, err = m.Status()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status[len(status)-1].Applied).NotTo(BeZero())
+			Expect(m.To(last.Version + 1)).To(MatchError(migrations.ErrUnknownVersion))
+		})
+
+		It("should not migrate while another instance holds the lock", func() {
+			lock := &migrations.Lock{ID: 1, Owner: "another", Locked: uint64(time.Now().UTC().Unix())}
+			_, err := suite.app.C.Orm.InsertOne(lock)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(suite.app.Migrator().Up()).To(MatchError(migrations.ErrLocked))
+
+			// lock of crashed instance expires
+			_, err = suite.app.C.Orm.ID(1).Cols("locked").Update(&migrations.Lock{Locked: lock.Locked - uint64(migrations.StaleLockAge.Seconds()) - 1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(suite.app.Migrator().Up()).To(Succeed())
+			count, err := suite.app.C.Orm.Count(&migrations.Lock{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(BeZero())
+		})
+
+		It("should adopt users table synced before migrations", func() {
+			dsn := tempDatabase()
+			defer os.Remove(dsn)
+			orm, err := xorm.NewEngine("sqlite3", dsn)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(orm.Sync(new(baselineUser))).To(Succeed())
+			_, err = orm.Insert(&baselineUser{Login: "a_test_baseline", Password: "hash", Created: 1459096113, Updated: 1459096113})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(orm.Close()).To(Succeed())
+
+			a, err := newBootstrapApp(dsn, "", "", "")
+			Expect(err).NotTo(HaveOccurred())
+			defer a.C.Orm.Close()
+			status, err := a.Migrator().Status()
+			Expect(err).NotTo(HaveOccurred())
+			for _, s := range status {
+				Expect(s.Applied).NotTo(BeZero(), s.Name)
+			}
+			user := users.User{Login: "a_test_baseline"}
+			Expect(user.Find(a.C.Orm)).To(Succeed())
+			Expect(user.Role).To(Equal(access.RoleUser))
+			Expect(user.Version).To(BeEquivalentTo(1))
+			Expect(user.TOTPEnabled).To(BeFalse())
+			_, err = a.C.Orm.ID(user.ID).Cols("totp_last_step").Update(&users.User{TOTPLastStep: 1})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = a.C.Orm.ID(user.ID).Delete(&users.User{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect((&users.User{Login: "a_test_baseline"}).Find(a.C.Orm)).To(Equal(users.ErrNotFound))
+		})
+
+		It("should report status of empty database without changing it", func() {
+			dsn := tempDatabase()
+			defer os.Remove(dsn)
+			orm, err := xorm.NewEngine("sqlite3", dsn)
+			Expect(err).NotTo(HaveOccurred())
+			defer orm.Close()
+			status, err := migrations.New(orm, suite.app.C.Logger, migrations.All).Status()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status).To(HaveLen(len(migrations.All)))
+			for _, s := range status {
+				Expect(s.Applied).To(BeZero(), s.Name)
+			}
+			exists, err := orm.IsTableExist(new(migrations.Record))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exists).To(BeFalse())
+		})
+
+		It("should report lock error other than taken lock as is", func() {
+			dsn := tempDatabase()
+			defer os.Remove(dsn)
+			a, err := newBootstrapApp(dsn, "", "", "")
+			Expect(err).NotTo(HaveOccurred())
+			defer a.C.Orm.Close()
+			_, err = a.C.Orm.Exec("CREATE TRIGGER a_test_lock_refused BEFORE INSERT ON schema_migrations_lock " +
+				"BEGIN SELECT RAISE(ABORT, 'a_test_lock_refused'); END")
+			Expect(err).NotTo(HaveOccurred())
+			err = a.Migrator().Up()
+			Expect(err).To(HaveOccurred())
+			Expect(err).NotTo(Equal(migrations.ErrLocked))
+			Expect(err.Error()).To(ContainSubstring("a_test_lock_refused"))
+		})
+	})
+})
+
+//------------------------------------------------------------------------------
+// baselineUser is users entity as it was synced before migrations
+type baselineUser struct {
+	ID            uint64 `xorm:"'id' pk autoincr unique notnull"`
+	Login         string `xorm:"text index not null unique 'login'"`
+	Email         string `xorm:"text 'email'"`
+	Password      string `xorm:"text not null 'password'"`
+	PasswordEtime uint64
+	Created       uint64 `xorm:"created"`
+	Updated       uint64 `xorm:"updated"`
+}
+
+// TableName used by xorm to set table name for entity
+func (u *baselineUser) TableName() string {
+	return "users"
+}
@@ -0,0 +1,80 @@
+package bddtests_test
+
+import (
+	"net/http"
+	"regexp"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/nilvxingren/echoxormdemo/server/audit"
+	"github.com/nilvxingren/echoxormdemo/server/auth"
+	"github.com/nilvxingren/echoxormdemo/server/users"
+)
+
+var _ = Describe("Test password reset", func() {
+	Context("with user having email", func() {
+		It("should reset password with mailed token once", func() {
+			_, err := suite.app.C.Orm.Where("login = ?", "a_test_user_04").Cols("email").Update(&users.User{Email: "user04@localhost"})
+			Expect(err).NotTo(HaveOccurred())
+
+			resp, err := suite.rc.R().SetBody(auth.ResetInput{Login: "a_test_user_04"}).Post("/auth/password-reset")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusAccepted))
+			// token comes by mail
+			var msg string
+			Eventually(suite.smtp.messages, 3*time.Second).Should(Receive(&msg))
+			Expect(msg).To(ContainSubstring("To: user04@localhost"))
+			token := regexp.MustCompile(`(?m)^([A-Za-z0-9_-]{43})\r$`).FindStringSubmatch(msg)
+			Expect(token).To(HaveLen(2))
+
+			confirm := auth.ResetConfirmInput{Token: token[1], NewPassword: "a_test_user_04_reset"}
+			resp, err = suite.rc.R().SetBody(confirm).Post("/auth/password-reset/confirm")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			// single use
+			resp, err = suite.rc.R().SetBody(confirm).Post("/auth/password-reset/confirm")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusBadRequest))
+			// both attempts are audited
+			result := new(audit.ListResult)
+			resp, err = suite.rc.R().SetResult(result).Get("/audit?limit=2&action=" + audit.ActionPasswordReset)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(result.Items).To(HaveLen(2))
+			Expect(result.Items[0].Outcome).To(Equal(audit.OutcomeFailure))
+			Expect(result.Items[1].Outcome).To(Equal(audit.OutcomeSuccess))
+			Expect(result.Items[1].Target).To(HavePrefix("user:"))
+			// new password works
+			resp, err = suite.rc.R().SetBody(auth.Input{Login: "a_test_user_04", Password: confirm.NewPassword}).Post("/auth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+		})
+	})
+	Context("with email shared by several users", func() {
+		It("should mail nobody", func() {
+			_, err := suite.app.C.Orm.In("login", "a_test_user_06", "a_test_user_07").Cols("email").Update(&users.User{Email: "shared@localhost"})
+			Expect(err).NotTo(HaveOccurred())
+
+			resp, err := suite.rc.R().SetBody(auth.ResetInput{Email: "shared@localhost"}).Post("/auth/password-reset")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusAccepted))
+			Consistently(suite.smtp.messages, time.Second).ShouldNot(Receive())
+			// login is unique
+			resp, err = suite.rc.R().SetBody(auth.ResetInput{Login: "a_test_user_06"}).Post("/auth/password-reset")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusAccepted))
+			var msg string
+			Eventually(suite.smtp.messages, 3*time.Second).Should(Receive(&msg))
+			Expect(msg).To(ContainSubstring("To: shared@localhost"))
+		})
+	})
+	Context("with unknown login", func() {
+		It("should respond the same way", func() {
+			resp, err := suite.rc.R().SetBody(auth.ResetInput{Login: "not-existing-login"}).Post("/auth/password-reset")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusAccepted))
+		})
+	})
+})
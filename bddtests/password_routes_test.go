@@ -0,0 +1,131 @@
+package bddtests_test
+
+import (
+	"net/http"
+	"strconv"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/resty.v0"
+
+	"github.com/nilvxingren/echoxormdemo/server/access"
+	"github.com/nilvxingren/echoxormdemo/server/apikeys"
+	"github.com/nilvxingren/echoxormdemo/server/auth"
+	"github.com/nilvxingren/echoxormdemo/server/users"
+)
+
+var _ = Describe("Test expired password", func() {
+	Context("on POST /auth", func() {
+		It("should allow password change only", func() {
+			user := new(users.User)
+			payload := users.Input{Login: "a_test_expired_user", Password: "a_test_expired_user"}
+			resp, err := suite.rc.R().SetBody(payload).SetResult(user).Post("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusCreated))
+			// expire password
+			_, err = suite.app.C.Orm.ID(user.ID).Cols("password_etime").Update(&users.User{PasswordEtime: 1})
+			Expect(err).NotTo(HaveOccurred())
+
+			// restricted token
+			result := new(auth.Result)
+			rc := resty.New().SetHeader("Content-Type", "application/json").SetHostURL(suite.baseURL)
+			resp, err = rc.R().SetBody(auth.Input{Login: payload.Login, Password: payload.Password}).SetResult(result).Post("/auth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(result.Result).To(Equal("PASSWORD_EXPIRED"))
+			Expect(result.RefreshToken).To(BeEmpty())
+			rc.SetAuthToken(result.Token)
+
+			resp, err = rc.R().Get("/users/" + strconv.FormatUint(user.ID, 10))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusForbidden))
+			// current password is required
+			change := users.PasswordInput{CurrentPassword: "wrong-password", NewPassword: "a_test_expired_user_new"}
+			resp, err = rc.R().SetBody(change).Post("/users/me/password")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusForbidden))
+			change.CurrentPassword = payload.Password
+			resp, err = rc.R().SetBody(change).Post("/users/me/password")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			// token of expired password is revoked
+			resp, err = rc.R().SetBody(change).Post("/users/me/password")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+
+			// new password gives full token
+			resp, err = rc.R().SetBody(auth.Input{Login: payload.Login, Password: change.NewPassword}).SetResult(result).Post("/auth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(result.Result).To(Equal("OK"))
+		})
+	})
+})
+
+var _ = Describe("Test POST /users/me/password", func() {
+	Context("with wrong current password", func() {
+		It("should lock login out", func() {
+			payload := users.Input{Login: "a_test_password_lockout", Password: "a_test_password_lockout"}
+			resp, err := suite.rc.R().SetBody(payload).Post("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusCreated))
+			rc := newAuthorizedClient(payload.Login, payload.Password)
+
+			change := users.PasswordInput{CurrentPassword: "wrong-password", NewPassword: "a_test_password_lockout_new"}
+			for i := 0; i < suite.app.C.Config.Auth.MaxFailedLogins; i++ {
+				resp, err = rc.R().SetBody(change).Post("/users/me/password")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode()).To(Equal(http.StatusForbidden))
+			}
+			change.CurrentPassword = payload.Password
+			resp, err = rc.R().SetBody(change).Post("/users/me/password")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusTooManyRequests))
+			Expect(resp.Header().Get("Retry-After")).NotTo(BeEmpty())
+			resp, err = rc.R().SetBody(auth.Input{Login: payload.Login, Password: payload.Password}).Post("/auth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusTooManyRequests))
+		})
+	})
+
+	Context("with right current password", func() {
+		It("should revoke every session of user", func() {
+			user := new(users.User)
+			payload := users.Input{Login: "a_test_password_sessions", Password: "a_test_password_sessions"}
+			resp, err := suite.rc.R().SetBody(payload).SetResult(user).Post("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusCreated))
+			id := strconv.FormatUint(user.ID, 10)
+			rc := newAuthorizedClient(payload.Login, payload.Password)
+			// other session with refresh token and API key
+			login := new(auth.Result)
+			other := resty.New().SetHeader("Content-Type", "application/json").SetHostURL(suite.baseURL)
+			resp, err = other.R().SetBody(auth.Input{Login: payload.Login, Password: payload.Password}).SetResult(login).Post("/auth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			other.SetAuthToken(login.Token)
+			key := new(apikeys.Result)
+			resp, err = other.R().SetBody(apikeys.Input{Name: "job", Scopes: []string{access.ScopeUsersRead}}).SetResult(key).Post("/users/me/api-keys")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusCreated))
+
+			change := users.PasswordInput{CurrentPassword: payload.Password, NewPassword: "a_test_password_sessions_new"}
+			resp, err = rc.R().SetBody(change).Post("/users/me/password")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+
+			resp, err = rc.R().Get("/users/" + id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+			resp, err = other.R().Get("/users/" + id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+			resp, err = other.R().SetBody(auth.RefreshInput{RefreshToken: login.RefreshToken}).Post("/auth/refresh")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+			resp, err = resty.New().SetHeader(apikeys.HeaderAPIKey, key.Key).SetHostURL(suite.baseURL).R().Get("/users/" + id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+		})
+	})
+})
@@ -0,0 +1,86 @@
+package bddtests_test
+
+import (
+	"encoding/json"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/resty.v0"
+
+	"github.com/nilvxingren/echoxormdemo/server/apikeys"
+	"github.com/nilvxingren/echoxormdemo/server/problem"
+	"github.com/nilvxingren/echoxormdemo/server/users"
+	"github.com/nilvxingren/echoxormdemo/validator"
+)
+
+var _ = Describe("Test error responses", func() {
+	Context("GET /users/:id of missing user", func() {
+		It("should respond with problem details", func() {
+			resp, err := suite.rc.R().Get("/users/999999")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusNotFound))
+			Expect(resp.Header().Get("Content-Type")).To(HavePrefix(problem.MIMEApplicationProblemJSON))
+			result := problemOf(resp)
+			Expect(result.Status).To(Equal(http.StatusNotFound))
+			Expect(result.Code).To(Equal("user_not_found"))
+			Expect(result.Title).To(Equal("Not Found"))
+			Expect(result.Instance).To(Equal("/users/999999"))
+		})
+	})
+
+	Context("POST /users with invalid input", func() {
+		It("should list every invalid field", func() {
+			payload := users.Input{Login: "_x", Email: "not an email", Password: "short", Role: "root"}
+			resp, err := suite.rc.R().SetBody(payload).Post("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusBadRequest))
+			result := problemOf(resp)
+			Expect(result.Code).To(Equal(problem.CodeValidation))
+			Expect(result.Errors).To(ConsistOf(
+				validator.FieldError{Field: "login", Rule: "min", Message: "must be at least 3 characters long"},
+				validator.FieldError{Field: "login", Rule: "login", Message: "must start with letter or digit"},
+				validator.FieldError{Field: "email", Rule: "email", Message: "must be an email address"},
+				validator.FieldError{Field: "password", Rule: "min", Message: "must be at least 8 characters long"},
+				validator.FieldError{Field: "password", Rule: "password", Message: "must mix two kinds of characters at least: lower case, upper case, digits, others"},
+				validator.FieldError{Field: "role", Rule: "oneof", Message: "must be one of: admin, user"},
+			))
+
+			resp, err = suite.rc.R().SetBody(users.Input{Login: "a_test_problem"}).Post("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusBadRequest))
+			Expect(problemOf(resp).Errors).To(ConsistOf(
+				validator.FieldError{Field: "password", Rule: "required", Message: "is required"},
+			))
+		})
+	})
+
+	Context("request with unknown credentials", func() {
+		It("should respond with problem details", func() {
+			rc := resty.New().
+				SetHeader("Content-Type", "application/json").
+				SetHostURL(suite.baseURL)
+
+			// error of application
+			resp, err := rc.R().SetHeader(apikeys.HeaderAPIKey, "exk_unknown").Get("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+			Expect(problemOf(resp).Code).To(Equal("invalid_api_key"))
+
+			// error of framework gets generic code
+			resp, err = rc.R().SetAuthToken("malformed").Get("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+			Expect(resp.Header().Get("Content-Type")).To(HavePrefix(problem.MIMEApplicationProblemJSON))
+			Expect(problemOf(resp).Code).To(Equal(problem.CodeUnauthorized))
+		})
+	})
+})
+
+//------------------------------------------------------------------------------
+// problemOf decodes problem details from error response
+func problemOf(resp *resty.Response) *problem.Problem {
+	result := new(problem.Problem)
+	Expect(json.Unmarshal(resp.Body(), result)).To(Succeed())
+	return result
+}
@@ -0,0 +1,120 @@
+package bddtests_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/resty.v0"
+
+	"github.com/nilvxingren/echoxormdemo/app"
+	"github.com/nilvxingren/echoxormdemo/ctx"
+	"github.com/nilvxingren/echoxormdemo/server/auth"
+	"github.com/nilvxingren/echoxormdemo/server/problem"
+	"github.com/nilvxingren/echoxormdemo/validator"
+)
+
+var _ = Describe("Test rate limit of /auth", func() {
+	Context("with low limit by client IP", func() {
+		It("should respond with 429 and Retry-After", func() {
+			rc, done := newAuthClient(suite.app.C, auth.NewRateLimiter(1, 2), auth.NewRateLimiter(600, 100))
+			defer done()
+			for i := 0; i < 2; i++ {
+				resp, err := rc.R().SetBody(auth.Input{Login: "a_test_user_0" + strconv.Itoa(i+2), Password: "wrong-password"}).Post("/auth")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+			}
+			// another login does not help, bucket is of IP
+			resp, err := rc.R().SetBody(auth.Input{Login: "a_test_user_05", Password: "wrong-password"}).Post("/auth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusTooManyRequests))
+			Expect(problemOf(resp).Code).To(Equal("rate_limited"))
+			retryAfter, err := strconv.Atoi(resp.Header().Get("Retry-After"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(retryAfter).To(BeNumerically(">", 0))
+			Expect(retryAfter).To(BeNumerically("<=", 61))
+		})
+	})
+
+	Context("with low limit by login", func() {
+		It("should throttle the login only", func() {
+			rc, done := newAuthClient(suite.app.C, auth.NewRateLimiter(600, 100), auth.NewRateLimiter(1, 1))
+			defer done()
+			payload := auth.Input{Login: "a_test_user_06", Password: "wrong-password"}
+			resp, err := rc.R().SetBody(payload).Post("/auth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+			resp, err = rc.R().SetBody(payload).Post("/auth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusTooManyRequests))
+			Expect(resp.Header().Get("Retry-After")).NotTo(BeEmpty())
+			// other login has its own bucket
+			resp, err = rc.R().SetBody(auth.Input{Login: "a_test_user_07", Password: "wrong-password"}).Post("/auth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Context("with empty bucket", func() {
+		It("should refill it at rate", func() {
+			limiter := auth.NewRateLimiter(1200, 1)
+			ok, _ := limiter.Allow("a")
+			Expect(ok).To(BeTrue())
+			ok, wait := limiter.Allow("a")
+			Expect(ok).To(BeFalse())
+			Expect(wait).To(BeNumerically(">", 0))
+			Expect(wait).To(BeNumerically("<=", 50*time.Millisecond))
+			ok, _ = limiter.Allow("b")
+			Expect(ok).To(BeTrue())
+			time.Sleep(wait + 10*time.Millisecond)
+			ok, _ = limiter.Allow("a")
+			Expect(ok).To(BeTrue())
+		})
+	})
+
+	Context("without [rate_limit] in config", func() {
+		It("should limit by defaults", func() {
+			data, err := ioutil.ReadFile(cfgFileName)
+			Expect(err).NotTo(HaveOccurred())
+			cfg := strings.NewReplacer("ip_per_minute = 600\n", "", "login_per_minute = 600\n", "").Replace(string(data))
+			file, err := ioutil.TempFile("", "echo-xorm-config")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.Remove(file.Name())
+			_, err = file.WriteString(cfg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(file.Close()).To(Succeed())
+
+			a, err := app.New(&ctx.Flags{CfgFileName: file.Name(), Command: "config"})
+			Expect(err).NotTo(HaveOccurred())
+			defer a.C.Orm.Close()
+			Expect(a.C.Config.RateLimit.IPPerMinute).To(BeNumerically(">", 0))
+			Expect(a.C.Config.RateLimit.LoginPerMinute).To(BeNumerically(">", 0))
+		})
+	})
+})
+
+//------------------------------------------------------------------------------
+// newAuthClient serves POST /auth of application context with given limiters,
+// so that low limits and other databases do not affect suite server
+func newAuthClient(c *ctx.Context, ip, login *auth.RateLimiter) (*resty.Client, func()) {
+	h := &auth.Handler{
+		C:            c,
+		Lockout:      auth.NewLockout(100, time.Minute),
+		IPLimiter:    ip,
+		LoginLimiter: login,
+	}
+	e := echo.New()
+	e.HTTPErrorHandler = problem.ErrorHandler(c.Logger)
+	e.Validator = validator.New()
+	e.POST("/auth", h.PostAuth, h.RateLimitIP)
+	server := httptest.NewServer(e)
+	rc := resty.New().SetHeader("Content-Type", "application/json").SetHostURL(server.URL)
+	return rc, server.Close
+}
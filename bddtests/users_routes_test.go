@@ -4,26 +4,160 @@ import (
 	"math/rand"
 	"net/http"
 	"strconv"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
-	"github.com/pfdsj/echoxormdemo/server/users"
+	"github.com/nilvxingren/echoxormdemo/server/access"
+	"github.com/nilvxingren/echoxormdemo/server/apikeys"
+	"github.com/nilvxingren/echoxormdemo/server/auth"
+	"github.com/nilvxingren/echoxormdemo/server/users"
 )
 
 var _ = Describe("Test GET /users", func() {
 	Context("Get all users", func() {
 		It("should respond properly", func() {
-			var orig, result []users.User
+			var orig []users.User
+			var result users.ListResult
 			// get orig
-			err := suite.app.C.Orm.Omit("password").Find(&orig)
+			err := suite.app.C.Orm.Omit("password").Asc("id").Find(&orig)
 			Expect(err).NotTo(HaveOccurred())
 			// get resp
-			resp, err := suite.rc.R().SetResult(&result).Get("/users")
+			resp, err := suite.rc.R().SetResult(&result).Get("/users?limit=500")
 			Expect(err).NotTo(HaveOccurred())
 			Expect(http.StatusOK).To(Equal(resp.StatusCode()))
 			Expect(len(orig)).To(BeNumerically(">=", 5))
-			Expect(len(result)).To(Equal(len(orig)))
-			Expect(result).To(BeEquivalentTo(orig))
+			Expect(result.Total).To(BeEquivalentTo(len(orig)))
+			Expect(result.Items).To(BeEquivalentTo(orig))
+			Expect(result.NextCursor).To(BeEmpty())
+		})
+	})
+
+	Context("Paginate with cursor", func() {
+		It("should walk through all users", func() {
+			var ids []uint64
+			path := "/users?limit=2&sort=-login"
+			for len(path) != 0 {
+				var result users.ListResult
+				resp, err := suite.rc.R().SetResult(&result).Get(path)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+				Expect(len(result.Items)).To(BeNumerically("<=", 2))
+				for _, u := range result.Items {
+					ids = append(ids, u.ID)
+				}
+				path = result.Links["next"]
+			}
+			var orig []users.User
+			err := suite.app.C.Orm.Omit("password").Desc("login").Asc("id").Find(&orig)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(ids)).To(Equal(len(orig)))
+			for i := range orig {
+				Expect(ids[i]).To(Equal(orig[i].ID))
+			}
+		})
+	})
+
+	Context("Paginate with cursor over equal values", func() {
+		It("should neither skip nor repeat users", func() {
+			var ids []uint64
+			pages := 0
+			path := "/users?limit=3&sort=-created,login"
+			for len(path) != 0 {
+				var result users.ListResult
+				resp, err := suite.rc.R().SetResult(&result).Get(path)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+				Expect(result.Limit).To(Equal(3))
+				Expect(result.Links["self"]).NotTo(BeEmpty())
+				if len(result.Links["next"]) != 0 {
+					Expect(result.NextCursor).NotTo(BeEmpty())
+					Expect(result.Links["next"]).To(ContainSubstring("cursor=" + result.NextCursor))
+				}
+				for _, u := range result.Items {
+					ids = append(ids, u.ID)
+				}
+				path = result.Links["next"]
+				pages++
+			}
+			var orig []users.User
+			err := suite.app.C.Orm.Omit("password").Desc("created").Asc("login").Asc("id").Find(&orig)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pages).To(BeNumerically(">", 1))
+			Expect(len(ids)).To(Equal(len(orig)))
+			for i := range orig {
+				Expect(ids[i]).To(Equal(orig[i].ID))
+			}
+		})
+	})
+
+	Context("Filter by creation time", func() {
+		It("should respond with users created in range inclusive", func() {
+			var result users.ListResult
+			from, to := uint64(1459099588), uint64(1459099669)
+			resp, err := suite.rc.R().SetResult(&result).
+				Get("/users?created_from=" + strconv.FormatUint(from, 10) + "&created_to=" + strconv.FormatUint(to, 10))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			count, err := suite.app.C.Orm.Where("created >= ? AND created <= ?", from, to).Count(&users.User{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(BeNumerically(">=", 2))
+			Expect(result.Total).To(Equal(count))
+			Expect(result.Items).To(HaveLen(int(count)))
+			for _, u := range result.Items {
+				Expect(u.Created).To(BeNumerically(">=", from))
+				Expect(u.Created).To(BeNumerically("<=", to))
+			}
+		})
+	})
+
+	Context("Filter by login prefix with wildcard", func() {
+		It("should match wildcard and escape characters literally", func() {
+			for _, prefix := range []string{"a%25", "a!%25", "a!_", "a_test_user!_0"} {
+				var result users.ListResult
+				resp, err := suite.rc.R().SetResult(&result).Get("/users?login_prefix=" + prefix)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode()).To(Equal(http.StatusOK), prefix)
+				Expect(result.Total).To(BeZero(), prefix)
+				Expect(result.Items).To(BeEmpty(), prefix)
+			}
+		})
+	})
+
+	Context("Paginate with offset", func() {
+		It("should skip users", func() {
+			var all, page users.ListResult
+			_, err := suite.rc.R().SetResult(&all).Get("/users?limit=4")
+			Expect(err).NotTo(HaveOccurred())
+			resp, err := suite.rc.R().SetResult(&page).Get("/users?limit=2&offset=2")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(page.Items).To(Equal(all.Items[2:4]))
+			Expect(page.Total).To(Equal(all.Total))
+		})
+	})
+
+	Context("Filter by login prefix", func() {
+		It("should respond with matching users only", func() {
+			var result users.ListResult
+			resp, err := suite.rc.R().SetResult(&result).Get("/users?login_prefix=a_test_user_0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(result.Items).NotTo(BeEmpty())
+			Expect(result.Total).To(BeEquivalentTo(len(result.Items)))
+			for _, u := range result.Items {
+				Expect(u.Login).To(HavePrefix("a_test_user_0"))
+			}
+		})
+	})
+
+	Context("with bad query", func() {
+		It("should respond with 400", func() {
+			for _, query := range []string{"sort=password", "limit=0", "offset=-1", "cursor=bm90LWEtY3Vyc29y", "created_from=yesterday"} {
+				resp, err := suite.rc.R().Get("/users?" + query)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode()).To(Equal(http.StatusBadRequest), query)
+			}
 		})
 	})
 })
@@ -75,6 +209,272 @@ var _ = Describe("Test POST /users", func() {
 	})
 })
 
+var _ = Describe("Test PUT /users/:id", func() {
+	Context("without optional fields", func() {
+		It("should replace user as a whole", func() {
+			user := new(users.User)
+			payload := users.Input{Login: "a_test_put_user", Email: "put@example.com", Password: "a_test_put_user"}
+			resp, err := suite.rc.R().SetBody(payload).SetResult(user).Post("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusCreated))
+			Expect(user.Email).To(Equal(payload.Email))
+
+			result := new(users.User)
+			resp, err = suite.rc.R().SetBody(users.Input{Login: "a_test_put_user_renamed"}).SetResult(result).
+				Put("/users/" + strconv.FormatUint(user.ID, 10))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			Expect(result.Login).To(Equal("a_test_put_user_renamed"))
+			Expect(result.Email).To(BeEmpty())
+			// password is kept
+			newAuthorizedClient("a_test_put_user_renamed", payload.Password)
+		})
+	})
+
+	Context("with password", func() {
+		It("should let admin only set it and revoke sessions", func() {
+			user := new(users.User)
+			payload := users.Input{Login: "a_test_put_password", Password: "a_test_put_password"}
+			resp, err := suite.rc.R().SetBody(payload).SetResult(user).Post("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusCreated))
+			path := "/users/" + strconv.FormatUint(user.ID, 10)
+			rc := newAuthorizedClient(payload.Login, payload.Password)
+
+			// own password needs current one
+			resp, err = rc.R().SetBody(users.Input{Login: payload.Login, Password: "a_test_new_password1"}).Put(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusForbidden))
+			Expect(problemOf(resp).Code).To(Equal("password_change_forbidden"))
+			resp, err = rc.R().SetHeader("Content-Type", "application/merge-patch+json").
+				SetBody(`{"password":"a_test_new_password1"}`).Patch(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusForbidden))
+			resp, err = rc.R().Get(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+
+			// admin sets it, sessions of old password are revoked
+			resp, err = suite.rc.R().SetBody(users.Input{Login: payload.Login, Password: "a_test_new_password2"}).Put(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			resp, err = rc.R().Get(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+			newAuthorizedClient(payload.Login, "a_test_new_password2")
+		})
+	})
+
+	Context("with role", func() {
+		It("should revoke sessions of role changed", func() {
+			user := new(users.User)
+			payload := users.Input{Login: "a_test_put_role", Password: "a_test_put_role", Role: access.RoleAdmin}
+			resp, err := suite.rc.R().SetBody(payload).SetResult(user).Post("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusCreated))
+			path := "/users/" + strconv.FormatUint(user.ID, 10)
+			rc := newAuthorizedClient(payload.Login, payload.Password)
+			resp, err = rc.R().Get("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+
+			// demoted admin
+			resp, err = suite.rc.R().SetHeader("Content-Type", "application/merge-patch+json").
+				SetBody(`{"role":"user"}`).Patch(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			resp, err = rc.R().Get("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+			resp, err = newAuthorizedClient(payload.Login, payload.Password).R().Get("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusForbidden))
+		})
+	})
+})
+
+var _ = Describe("Test PATCH /users/:id", func() {
+	var path string
+
+	BeforeEach(func() {
+		if len(path) != 0 {
+			return
+		}
+		user := new(users.User)
+		payload := users.Input{Login: "a_test_patch_user", Password: "a_test_patch_user"}
+		resp, err := suite.rc.R().SetBody(payload).SetResult(user).Post("/users")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode()).To(Equal(http.StatusCreated))
+		path = "/users/" + strconv.FormatUint(user.ID, 10)
+	})
+
+	patch := func(mediaType, body string, result *users.User) int {
+		resp, err := suite.rc.R().SetHeader("Content-Type", mediaType).SetBody(body).SetResult(result).Patch(path)
+		Expect(err).NotTo(HaveOccurred())
+		return resp.StatusCode()
+	}
+
+	Context("with merge patch", func() {
+		It("should set and clear fields", func() {
+			result := new(users.User)
+			Expect(patch("application/merge-patch+json", `{"email":"patch@example.com"}`, result)).To(Equal(http.StatusOK))
+			Expect(result.Email).To(Equal("patch@example.com"))
+			Expect(result.Login).To(Equal("a_test_patch_user"))
+
+			result = new(users.User)
+			Expect(patch("application/merge-patch+json", `{"email":null}`, result)).To(Equal(http.StatusOK))
+			Expect(result.Email).To(BeEmpty())
+			Expect(result.Login).To(Equal("a_test_patch_user"))
+		})
+	})
+
+	Context("with JSON patch", func() {
+		It("should apply operations", func() {
+			result := new(users.User)
+			body := `[{"op":"test","path":"/login","value":"a_test_patch_user"},{"op":"replace","path":"/email","value":"jp@example.com"}]`
+			Expect(patch("application/json-patch+json", body, result)).To(Equal(http.StatusOK))
+			Expect(result.Email).To(Equal("jp@example.com"))
+
+			body = `[{"op":"test","path":"/login","value":"someone_else"},{"op":"remove","path":"/email"}]`
+			Expect(patch("application/json-patch+json", body, new(users.User))).To(Equal(http.StatusConflict))
+		})
+	})
+
+	Context("with bad patch", func() {
+		It("should respond with error", func() {
+			Expect(patch("application/merge-patch+json", `{"id":5}`, new(users.User))).To(Equal(http.StatusUnprocessableEntity))
+			Expect(patch("application/merge-patch+json", `{"login":null}`, new(users.User))).To(Equal(http.StatusBadRequest))
+			Expect(patch("application/merge-patch+json", `{"email":"not an email"}`, new(users.User))).To(Equal(http.StatusBadRequest))
+			Expect(patch("application/merge-patch+json", `{"login":`, new(users.User))).To(Equal(http.StatusBadRequest))
+			Expect(patch("text/plain", `{}`, new(users.User))).To(Equal(http.StatusUnsupportedMediaType))
+		})
+	})
+})
+
+var _ = Describe("Test conditional requests to /users/:id", func() {
+	Context("with ETag of user", func() {
+		It("should honor If-None-Match and If-Match", func() {
+			user := new(users.User)
+			payload := users.Input{Login: "a_test_etag_user", Password: "a_test_etag_user"}
+			resp, err := suite.rc.R().SetBody(payload).SetResult(user).Post("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusCreated))
+			path := "/users/" + strconv.FormatUint(user.ID, 10)
+
+			resp, err = suite.rc.R().Get(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			etag := resp.Header().Get("ETag")
+			Expect(etag).NotTo(BeEmpty())
+			// not modified
+			resp, err = suite.rc.R().SetHeader("If-None-Match", etag).Get(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusNotModified))
+			// update with matching tag
+			resp, err = suite.rc.R().SetHeader("If-Match", etag).
+				SetHeader("Content-Type", "application/merge-patch+json").
+				SetBody(`{"email":"etag@example.com"}`).Patch(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			newEtag := resp.Header().Get("ETag")
+			Expect(newEtag).NotTo(Equal(etag))
+			// stale tag
+			resp, err = suite.rc.R().SetHeader("If-Match", etag).SetBody(users.Input{Login: "filler"}).Put(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusPreconditionFailed))
+			resp, err = suite.rc.R().SetHeader("If-Match", etag).Delete(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusPreconditionFailed))
+			// weak tags never match If-Match
+			resp, err = suite.rc.R().SetHeader("If-Match", "W/"+newEtag).Delete(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusPreconditionFailed))
+			resp, err = suite.rc.R().SetHeader("If-Match", newEtag).Delete(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+		})
+	})
+})
+
+var _ = Describe("Test DELETE /users/:id", func() {
+	Context("then restore and purge", func() {
+		It("should delete softly", func() {
+			user := new(users.User)
+			payload := users.Input{Login: "a_test_deleted_user", Password: "a_test_deleted_user"}
+			resp, err := suite.rc.R().SetBody(payload).SetResult(user).Post("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusCreated))
+			path := "/users/" + strconv.FormatUint(user.ID, 10)
+			rc := newAuthorizedClient(payload.Login, payload.Password)
+			login := new(auth.Result)
+			resp, err = suite.rc.R().SetBody(auth.Input{Login: payload.Login, Password: payload.Password}).SetResult(login).Post("/auth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+
+			resp, err = suite.rc.R().Delete(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			// sessions are revoked
+			resp, err = rc.R().Get(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+			resp, err = suite.rc.R().SetBody(auth.RefreshInput{RefreshToken: login.RefreshToken}).Post("/auth/refresh")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+			// hidden
+			resp, err = suite.rc.R().Get(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusNotFound))
+			list := new(users.ListResult)
+			_, err = suite.rc.R().SetResult(list).Get("/users?login_prefix=" + payload.Login)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(list.Total).To(BeZero())
+			// can not log in, login stays taken
+			resp, err = suite.rc.R().SetBody(auth.Input{Login: payload.Login, Password: payload.Password}).Post("/auth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+			resp, err = suite.rc.R().SetBody(payload).Post("/users")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusConflict))
+
+			// restore
+			resp, err = suite.rc.R().Post(path + "/restore")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			resp, err = suite.rc.R().Post(path + "/restore")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusConflict))
+			// tokens issued before delete stay revoked
+			resp, err = rc.R().Get(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusUnauthorized))
+			resp, err = newAuthorizedClient(payload.Login, payload.Password).R().
+				SetBody(apikeys.Input{Name: "job", Scopes: []string{access.ScopeUsersRead}}).Post("/users/me/api-keys")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusCreated))
+
+			// purge, with tokens and keys of user
+			resp, err = suite.rc.R().Delete(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusOK))
+			purged, err := users.PurgeDeleted(suite.app.C.Orm, time.Now().Add(time.Hour), (&auth.Handler{C: suite.app.C}).PurgeSessions)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(purged).To(BeNumerically(">=", 1))
+			count, err := suite.app.C.Orm.Unscoped().ID(user.ID).Count(&users.User{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(BeZero())
+			for _, bean := range []interface{}{new(auth.RefreshToken), new(auth.RevokedToken), new(apikeys.APIKey)} {
+				count, err = suite.app.C.Orm.Where("user_id = ?", user.ID).Count(bean)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(count).To(BeZero())
+			}
+			resp, err = suite.rc.R().Post(path + "/restore")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode()).To(Equal(http.StatusNotFound))
+		})
+	})
+})
+
 /*
 import (
 	"encoding/json"
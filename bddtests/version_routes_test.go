@@ -4,7 +4,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
-	"github.com/pfdsj/echoxormdemo/server/version"
+	"github.com/nilvxingren/echoxormdemo/server/version"
 )
 
 var _ = Describe("Test /version", func() {
@@ -0,0 +1,139 @@
+// Package cli runs commands of the binary: server and operator commands that
+// work with database directly, without HTTP API and token
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nilvxingren/echoxormdemo/app"
+	"github.com/nilvxingren/echoxormdemo/ctx"
+)
+
+// command is a subcommand of the binary
+type command struct {
+	usage string
+	about string
+	run   func(a *app.Application, args []string) error
+}
+
+var commands = map[string]command{
+	"serve":   {"serve", "start HTTP server (default)", serve},
+	"migrate": {"migrate up|down|status|to N", "manage database schema", migrate},
+	"user":    {"user create|list|disable|set-password", "manage users, see -h of subcommand", user},
+	"config":  {"config check|print", "check configuration and database connection, print effective configuration", config},
+	"token":   {"token issue --user LOGIN", "issue access token for debugging", token},
+}
+
+// Run parses global flags and runs command given by args, server is run if there is no command
+func Run(args []string) error {
+	err := run(args)
+	// usage is printed already
+	if err == flag.ErrHelp {
+		return nil
+	}
+	return err
+}
+
+//------------------------------------------------------------------------------
+func run(args []string) error {
+	fs := flag.NewFlagSet("echoxormdemo", flag.ContinueOnError)
+	configFlag := fs.String("config", "./resource/config.toml", "-config=\"path-to-your-config-file\" ")
+	// every config key is a flag too
+	overrides := map[string]string{}
+	for _, key := range new(ctx.Config).Keys() {
+		fs.Var(override{name: key.Name, values: overrides}, key.Name, "overrides "+key.Name+" (env "+ctx.EnvName(key.Name)+")")
+	}
+	fs.Usage = func() { usage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	name := fs.Arg(0)
+	if len(name) == 0 {
+		name = "serve"
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		usage(fs)
+		return errors.New("unknown command " + name)
+	}
+
+	flags := &ctx.Flags{CfgFileName: *configFlag, Overrides: overrides}
+	// server migrates and bootstraps database, commands do it on demand
+	if name != "serve" {
+		flags.Command = name
+	}
+	a, err := app.New(flags)
+	if err != nil {
+		return errors.New("initialization error: " + err.Error())
+	}
+	defer func() {
+		if a.C.Orm != nil {
+			a.C.Orm.Close()
+		}
+	}()
+	if len(fs.Args()) == 0 {
+		return cmd.run(a, nil)
+	}
+	return cmd.run(a, fs.Args()[1:])
+}
+
+func usage(fs *flag.FlagSet) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "Usage: echoxormdemo [-config=file] [-key=value...] [command]")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s\t%s\n", commands[name].usage, commands[name].about)
+	}
+	w.Flush()
+	fmt.Fprintln(os.Stderr, "Flags:")
+	fs.PrintDefaults()
+}
+
+// subcommand returns name of subcommand and its arguments
+func subcommand(args []string, expected string) (string, []string, error) {
+	if len(args) == 0 {
+		return "", nil, errors.New("subcommand expected: " + expected)
+	}
+	return args[0], args[1:], nil
+}
+
+// readSecret reads secret from stdin if it is not given, so that it is not kept in shell history
+func readSecret(secret, prompt string) (string, error) {
+	if len(secret) != 0 {
+		return secret, nil
+	}
+	fmt.Fprint(os.Stderr, prompt+": ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && len(line) == 0 {
+		return "", errors.New(prompt + " not read: " + err.Error())
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// override is a flag of config key, it is applied over file and environment
+type override struct {
+	name   string
+	values map[string]string
+}
+
+func (o override) String() string {
+	return ""
+}
+
+func (o override) Set(value string) error {
+	o.values[o.name] = value
+	return nil
+}
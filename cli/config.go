@@ -0,0 +1,32 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/nilvxingren/echoxormdemo/app"
+)
+
+// config is a command: config check|print. Configuration is checked by app.New
+// already, check pings database in addition. Print writes effective configuration
+// as TOML with secrets redacted
+func config(a *app.Application, args []string) error {
+	name, _, err := subcommand(args, "check or print")
+	if err != nil {
+		return err
+	}
+	switch name {
+	case "check":
+		if err = a.C.Orm.Ping(); err != nil {
+			return errors.New("database is not available: " + err.Error())
+		}
+		fmt.Println("configuration is OK")
+		return nil
+	case "print":
+		return toml.NewEncoder(os.Stdout).Encode(a.C.Config.Redacted())
+	}
+	return errors.New("unknown config command " + name + ", expected check or print")
+}
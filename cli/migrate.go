@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nilvxingren/echoxormdemo/app"
+)
+
+// migrate is a command: migrate up|down|status|to N
+func migrate(a *app.Application, args []string) error {
+	m := a.Migrator()
+	if len(args) == 0 {
+		args = []string{"status"}
+	}
+	switch args[0] {
+	case "up":
+		return m.Up()
+	case "down":
+		return m.Down()
+	case "to":
+		if len(args) < 2 {
+			return errors.New("version expected: migrate to N")
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return errors.New("version not recognized: " + args[1])
+		}
+		return m.To(version)
+	case "status":
+		status, err := m.Status()
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			applied := "pending"
+			if s.Applied != 0 {
+				applied = time.Unix(int64(s.Applied), 0).UTC().Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-24s  %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	}
+	return errors.New("unknown migrate command " + args[0] + ", expected up, down, status or to N")
+}
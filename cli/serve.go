@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/nilvxingren/echoxormdemo/app"
+)
+
+// serve is a command: serve
+func serve(a *app.Application, args []string) error {
+	if a.C.Logger == nil {
+		return errors.New("startup error: logger not initialized")
+	}
+	// setup OS-signal catchers
+	signalChannel := make(chan os.Signal, 1)
+	signal.Notify(signalChannel, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() { // start OS-signal catching route
+		for sig := range signalChannel {
+			if a.C.Orm != nil {
+				if err := a.C.Orm.Close(); err != nil {
+					a.C.Logger.Error("appcontrol", os.Args[0]+" db closing error on "+sig.String())
+				}
+			}
+			a.C.Logger.Info("appcontrol", os.Args[0]+" graceful shutdown on "+sig.String())
+			a.C.Logger.Close()
+			os.Exit(1)
+		}
+	}()
+
+	// run application server
+	a.C.Logger.Info("appcontrol", "started on localhost:"+a.C.Config.Port)
+	a.Run()
+	return nil
+}
@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/nilvxingren/echoxormdemo/app"
+	"github.com/nilvxingren/echoxormdemo/server/auth"
+	"github.com/nilvxingren/echoxormdemo/server/users"
+)
+
+// token is a command: token issue --user LOGIN. Access token is printed to
+// stdout, it is not limited by expired password nor by second factor
+func token(a *app.Application, args []string) error {
+	name, args, err := subcommand(args, "issue")
+	if err != nil {
+		return err
+	}
+	if name != "issue" {
+		return errors.New("unknown token command " + name + ", expected issue")
+	}
+	fs := flag.NewFlagSet("token issue", flag.ContinueOnError)
+	login := fs.String("user", "", "login of user token is issued for")
+	if err = fs.Parse(args); err != nil {
+		return err
+	}
+	if len(*login) == 0 {
+		return errors.New("user expected: token issue --user LOGIN")
+	}
+
+	u := users.User{Login: *login}
+	if err = u.Find(a.C.Orm); err != nil {
+		return err
+	}
+	h := auth.Handler{C: a.C}
+	result, err := h.IssueAccessToken(&u)
+	if err != nil {
+		return err
+	}
+	fmt.Println(result.Token)
+	return nil
+}
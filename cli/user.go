@@ -0,0 +1,163 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/nilvxingren/echoxormdemo/app"
+	"github.com/nilvxingren/echoxormdemo/server/access"
+	"github.com/nilvxingren/echoxormdemo/server/audit"
+	"github.com/nilvxingren/echoxormdemo/server/auth"
+	"github.com/nilvxingren/echoxormdemo/server/users"
+	"github.com/nilvxingren/echoxormdemo/validator"
+)
+
+// user is a command: user create|list|disable|set-password
+func user(a *app.Application, args []string) error {
+	name, args, err := subcommand(args, "create, list, disable or set-password")
+	if err != nil {
+		return err
+	}
+	switch name {
+	case "create":
+		return userCreate(a, args)
+	case "list":
+		return userList(a, args)
+	case "disable":
+		return userDisable(a, args)
+	case "set-password":
+		return userSetPassword(a, args)
+	}
+	return errors.New("unknown user command " + name + ", expected create, list, disable or set-password")
+}
+
+// userCreate is a command: user create --login LOGIN [--email EMAIL] [--role ROLE] [--password PASSWORD]
+func userCreate(a *app.Application, args []string) error {
+	var input users.CreateInput
+	fs := flag.NewFlagSet("user create", flag.ContinueOnError)
+	fs.StringVar(&input.Login, "login", "", "login of new user")
+	fs.StringVar(&input.Email, "email", "", "email of new user")
+	fs.StringVar(&input.Role, "role", access.RoleUser, "role of new user: admin or user")
+	fs.StringVar(&input.Password, "password", "", "password of new user, read from stdin if omitted")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var err error
+	if input.Password, err = readSecret(input.Password, "password"); err != nil {
+		return err
+	}
+	if err = validator.New().Validate(&input); err != nil {
+		return err
+	}
+
+	u := users.User{
+		Login:         input.Login,
+		Email:         input.Email,
+		Password:      input.Password,
+		PasswordEtime: users.PasswordEtime(a.C.Config.Auth.PasswordLifetime.Duration),
+		Role:          input.Role,
+	}
+	if err = u.Save(a.C.Orm); err != nil {
+		return err
+	}
+	record(a, audit.Command(audit.ActionUserCreate, audit.UserTarget(u.ID)).WithChanges(audit.Diff(&users.User{}, &u)))
+	fmt.Printf("user %s created with id %d\n", u.Login, u.ID)
+	return nil
+}
+
+// userList is a command: user list
+func userList(a *app.Application, args []string) error {
+	var u users.User
+	list, err := u.FindAll(a.C.Orm)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tLOGIN\tEMAIL\tROLE")
+	for _, u := range list {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", u.ID, u.Login, u.Email, u.Role)
+	}
+	return w.Flush()
+}
+
+// userDisable is a command: user disable --login LOGIN.
+// User is deleted softly and its sessions are revoked, it may be restored by POST /users/{id}/restore
+func userDisable(a *app.Application, args []string) error {
+	u, err := parseLogin(args, "user disable")
+	if err != nil {
+		return err
+	}
+	if err = u.Find(a.C.Orm); err != nil {
+		return err
+	}
+	if err = u.Delete(a.C.Orm); err != nil {
+		return err
+	}
+	record(a, audit.Command(audit.ActionUserDelete, audit.UserTarget(u.ID)))
+	h := auth.Handler{C: a.C}
+	if err = h.RevokeSessions(u.ID); err != nil {
+		return err
+	}
+	fmt.Printf("user %s disabled\n", u.Login)
+	return nil
+}
+
+// userSetPassword is a command: user set-password --login LOGIN [--password PASSWORD].
+// Password does not expire before its lifetime, sessions of user are revoked
+func userSetPassword(a *app.Application, args []string) error {
+	var password string
+	fs := flag.NewFlagSet("user set-password", flag.ContinueOnError)
+	login := fs.String("login", "", "login of user")
+	fs.StringVar(&password, "password", "", "new password, read from stdin if omitted")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	u := users.User{Login: *login}
+	if len(u.Login) == 0 {
+		return errors.New("login expected: user set-password --login LOGIN")
+	}
+	err := u.Find(a.C.Orm)
+	if err != nil {
+		return err
+	}
+	if password, err = readSecret(password, "password"); err != nil {
+		return err
+	}
+	if err = validator.New().Validate(&users.CreateInput{Login: u.Login, Password: password}); err != nil {
+		return err
+	}
+
+	before := u
+	if err = u.SetPassword(a.C.Orm, password, users.PasswordEtime(a.C.Config.Auth.PasswordLifetime.Duration)); err != nil {
+		return err
+	}
+	record(a, audit.Command(audit.ActionUserUpdate, audit.UserTarget(u.ID)).WithChanges(audit.Diff(&before, &u)))
+	h := auth.Handler{C: a.C}
+	if err = h.RevokeSessions(u.ID); err != nil {
+		return err
+	}
+	fmt.Printf("password of user %s set\n", u.Login)
+	return nil
+}
+
+//------------------------------------------------------------------------------
+// parseLogin parses --login flag of command, user is not read from database yet
+func parseLogin(args []string, name string) (*users.User, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	login := fs.String("login", "", "login of user")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if len(*login) == 0 {
+		return nil, errors.New("login expected: " + name + " --login LOGIN")
+	}
+	return &users.User{Login: *login}, nil
+}
+
+// record saves audit entry of command, errors are written to application log only
+func record(a *app.Application, entry *audit.Entry) {
+	audit.Record(a.C, entry)
+}
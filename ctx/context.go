@@ -1,15 +1,20 @@
 package ctx
 
 import (
+	"time"
 
-	"github.com/nilvxingren/echoxormdemo/logger"
 	"github.com/go-xorm/xorm"
+	"github.com/nilvxingren/echoxormdemo/keys"
+	"github.com/nilvxingren/echoxormdemo/logger"
+	"github.com/nilvxingren/echoxormdemo/mailer"
 )
 
 // Context is a gate to application services
 type Context struct {
 	Orm    *xorm.Engine
 	Logger logger.Logger
+	Keys   *keys.KeySet
+	Mailer mailer.Mailer
 	Config *Config
 	Flags  *Flags
 }
@@ -17,20 +22,86 @@ type Context struct {
 // Flags represents start mode parameters for application
 type Flags struct {
 	CfgFileName string
+	Command     string            // command run instead of server, it manages database itself
+	Overrides   map[string]string // config keys set by flags, they override file and environment
 }
 
 // Config is a storage for admin application configuration
 type Config struct {
-	Secret   string `toml:"secret"`
+	Title    string `toml:"title"`
+	Secret   string `toml:"secret" secret:"true"`
 	Version  string `toml:"version"`
 	Port     string `toml:"port"`
+	Mode     string `toml:"mode"` // "development" or "production"
 	Database struct {
-		Db  string `toml:"db"`
-		Dsn string `toml:"dsn"`
+		Db          string `toml:"db"`
+		Dsn         string `toml:"dsn" secret:"true"`
+		AutoMigrate bool   `toml:"auto_migrate"`
 	} `toml:"database"`
 	Logging struct {
 		LogMode string `toml:"log_mode"`
 		LogTag  string `toml:"log_tag"`
-		ID      string // will be process id
+		ID      string `toml:"id"` // process id if empty
 	} `toml:"logging"`
+	JWT struct {
+		Algorithm  string        `toml:"algorithm"`
+		SigningKey string        `toml:"signing_key"`
+		Keys       []keys.Config `toml:"keys"`
+	} `toml:"jwt"`
+	Auth struct {
+		MaxFailedLogins  int      `toml:"max_failed_logins"`
+		LockoutTime      Duration `toml:"lockout_time"`
+		AccessTokenTTL   Duration `toml:"access_token_ttl"`
+		RefreshTokenTTL  Duration `toml:"refresh_token_ttl"`
+		PasswordLifetime Duration `toml:"password_lifetime"`
+		PasswordResetTTL Duration `toml:"password_reset_ttl"`
+		PasswordResetURL string   `toml:"password_reset_url"`
+	} `toml:"auth"`
+	RateLimit struct {
+		IPPerMinute    int `toml:"ip_per_minute"`
+		IPBurst        int `toml:"ip_burst"`
+		LoginPerMinute int `toml:"login_per_minute"`
+		LoginBurst     int `toml:"login_burst"`
+	} `toml:"rate_limit"`
+	Users struct {
+		Retention        Duration `toml:"retention"`
+		PurgeInterval    Duration `toml:"purge_interval"`
+		ImportMaxRows    int      `toml:"import_max_rows"`
+		ImportBcryptCost int      `toml:"import_bcrypt_cost"`
+	} `toml:"users"`
+	MFA struct {
+		Issuer        string `toml:"issuer"`
+		EncryptionKey string `toml:"encryption_key" secret:"true"`
+	} `toml:"mfa"`
+	Bootstrap struct {
+		AdminLogin        string `toml:"admin_login"`
+		AdminPassword     string `toml:"admin_password" secret:"true"`
+		AdminPasswordHash string `toml:"admin_password_hash" secret:"true"`
+	} `toml:"bootstrap"`
+	Mail struct {
+		Mode         string `toml:"mode"`
+		From         string `toml:"from"`
+		SMTPHost     string `toml:"smtp_host"`
+		SMTPPort     string `toml:"smtp_port"`
+		SMTPUsername string `toml:"smtp_username"`
+		SMTPPassword string `toml:"smtp_password" secret:"true"`
+		File         string `toml:"file"`
+	} `toml:"mail"`
+}
+
+// Duration is a time.Duration decoded from config strings like "15m" or "72h"
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
 }
@@ -0,0 +1,132 @@
+package ctx
+
+import (
+	"encoding"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix is a prefix of environment variables that override config keys,
+// e.g. ECHOXORM_DATABASE_DSN overrides database.dsn. Variable with _FILE
+// suffix names a file holding the value (Docker and Kubernetes secrets)
+const EnvPrefix = "ECHOXORM_"
+
+// redacted replaces values of secret keys in printed config
+const redacted = "******"
+
+// Key is a config key that may be overridden by environment and flags.
+// Keys are strings, numbers, booleans and durations, arrays of tables are not keys
+type Key struct {
+	Name   string // dotted path of TOML keys, e.g. "database.dsn"
+	Secret bool   // value is redacted in printed config
+	value  reflect.Value
+}
+
+// Keys lists keys of config ordered by name
+func (c *Config) Keys() []Key {
+	keys := appendKeys(nil, "", reflect.ValueOf(c).Elem())
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys
+}
+
+// Set parses value of key given by name
+func (c *Config) Set(name, value string) error {
+	for _, key := range c.Keys() {
+		if key.Name == name {
+			return key.set(value)
+		}
+	}
+	return errors.New("unknown config key " + name)
+}
+
+// LoadEnv overrides keys by environment variables, lookup is os.LookupEnv usually
+func (c *Config) LoadEnv(lookup func(string) (string, bool)) error {
+	for _, key := range c.Keys() {
+		env := EnvName(key.Name)
+		value, ok := lookup(env)
+		if file, fileOK := lookup(env + "_FILE"); fileOK {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return errors.New(env + "_FILE: " + err.Error())
+			}
+			value, ok = strings.TrimRight(string(data), "\r\n"), true
+		}
+		if !ok {
+			continue
+		}
+		if err := key.set(value); err != nil {
+			return errors.New(env + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Redacted returns copy of config with values of secret keys replaced
+func (c *Config) Redacted() *Config {
+	clone := *c
+	for _, key := range clone.Keys() {
+		if key.Secret && key.value.Len() != 0 {
+			key.value.SetString(redacted)
+		}
+	}
+	return &clone
+}
+
+// EnvName returns name of environment variable that overrides key
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+//------------------------------------------------------------------------------
+// appendKeys appends keys of struct v, prefix is a dotted path of v
+func appendKeys(keys []Key, prefix string, v reflect.Value) []Key {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := strings.Split(sf.Tag.Get("toml"), ",")[0]
+		if len(tag) == 0 || tag == "-" {
+			continue
+		}
+		name := prefix + tag
+		fv := v.Field(i)
+		if _, ok := fv.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			keys = append(keys, Key{Name: name, Secret: sf.Tag.Get("secret") == "true", value: fv})
+			continue
+		}
+		switch fv.Kind() {
+		case reflect.Struct:
+			keys = appendKeys(keys, name+".", fv)
+		case reflect.String, reflect.Int, reflect.Bool:
+			keys = append(keys, Key{Name: name, Secret: sf.Tag.Get("secret") == "true", value: fv})
+		}
+	}
+	return keys
+}
+
+// set parses value into key
+func (k Key) set(value string) error {
+	if u, ok := k.value.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(value))
+	}
+	switch k.value.Kind() {
+	case reflect.String:
+		k.value.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.New(k.Name + " must be integer")
+		}
+		k.value.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New(k.Name + " must be boolean")
+		}
+		k.value.SetBool(b)
+	}
+	return nil
+}
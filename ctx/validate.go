@@ -0,0 +1,80 @@
+package ctx
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MinSecretLength is a minimal length of secret that signs tokens and encrypts TOTP secrets
+const MinSecretLength = 16
+
+// Values of config keys that are recognized, database.db is one of drivers
+// registered in binary (sql.Drivers)
+var (
+	LogModes  = []string{"std", "stdout", "nil", "null"}
+	MailModes = []string{"smtp", "file", "log"}
+	Modes     = []string{"development", "production"}
+)
+
+// ConfigErrors lists every problem of config
+type ConfigErrors []string
+
+// Error implements error interface
+func (e ConfigErrors) Error() string {
+	return "Configuration is invalid:\n  - " + strings.Join(e, "\n  - ")
+}
+
+// Validate checks config with defaults filled already. Returns ConfigErrors or nil
+func (c *Config) Validate() error {
+	var errs ConfigErrors
+
+	if len(c.Secret) < MinSecretLength {
+		errs = append(errs, "secret must be at least "+strconv.Itoa(MinSecretLength)+" characters long (set it by ECHOXORM_SECRET or ECHOXORM_SECRET_FILE)")
+	}
+	if port, err := strconv.Atoi(c.Port); err != nil || port <= 0 || port > 65535 {
+		errs = append(errs, "port must be a number in 1..65535, got \""+c.Port+"\"")
+	}
+	if !oneOf(c.Mode, Modes) {
+		errs = append(errs, "mode must be one of "+strings.Join(Modes, ", ")+", got \""+c.Mode+"\"")
+	}
+	if drivers := sql.Drivers(); !oneOf(c.Database.Db, drivers) {
+		errs = append(errs, "database.db must be one of drivers built in: "+strings.Join(drivers, ", ")+", got \""+c.Database.Db+"\"")
+	}
+	if len(c.Database.Dsn) == 0 {
+		errs = append(errs, "database.dsn is required")
+	}
+	if len(c.Logging.LogMode) != 0 && !oneOf(c.Logging.LogMode, LogModes) {
+		errs = append(errs, "logging.log_mode must be one of "+strings.Join(LogModes, ", ")+", got \""+c.Logging.LogMode+"\"")
+	}
+	if len(c.Mail.Mode) != 0 && !oneOf(c.Mail.Mode, MailModes) {
+		errs = append(errs, "mail.mode must be one of "+strings.Join(MailModes, ", ")+", got \""+c.Mail.Mode+"\"")
+	}
+	if c.Mail.Mode == "file" && len(c.Mail.File) == 0 {
+		errs = append(errs, "mail.file is required in \"file\" mail mode")
+	}
+	if cost := c.Users.ImportBcryptCost; cost != 0 && (cost < bcrypt.MinCost || cost > bcrypt.MaxCost) {
+		errs = append(errs, "users.import_bcrypt_cost must be in "+strconv.Itoa(bcrypt.MinCost)+".."+strconv.Itoa(bcrypt.MaxCost)+", got "+strconv.Itoa(c.Users.ImportBcryptCost))
+	}
+	// password reset tokens would be readable by anyone reading application log
+	if c.Mode == "production" && (len(c.Mail.Mode) == 0 || c.Mail.Mode == "log") {
+		errs = append(errs, "mail.mode \"log\" writes password reset tokens to application log, it is refused in production mode")
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+//------------------------------------------------------------------------------
+func oneOf(value string, values []string) bool {
+	for _, v := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
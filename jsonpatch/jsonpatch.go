@@ -0,0 +1,272 @@
+// Package jsonpatch applies JSON Merge Patch (RFC 7396) and JSON Patch (RFC 6902) to JSON documents
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Media types of patch documents
+const (
+	MIMEMergePatch = "application/merge-patch+json"
+	MIMEJSONPatch  = "application/json-patch+json"
+)
+
+// Errors of patch application
+var (
+	ErrTestFailed = errors.New("patch test operation failed")
+	ErrBadPath    = errors.New("patch path does not exist")
+)
+
+// Operation is an operation of JSON Patch
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// MergePatch applies merge patch to document
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	var target, p interface{}
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, err
+	}
+	return json.Marshal(merge(target, p))
+}
+
+// Apply applies JSON Patch (list of operations) to document. Document is
+// not changed if any of operations fails
+func Apply(doc, patch []byte) ([]byte, error) {
+	var (
+		target interface{}
+		ops    []Operation
+		err    error
+	)
+	if err = json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(patch, &ops); err != nil {
+		return nil, err
+	}
+	for _, op := range ops {
+		target, err = apply(target, op)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(target)
+}
+
+//------------------------------------------------------------------------------
+func merge(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for name, value := range p {
+		if value == nil {
+			delete(t, name)
+			continue
+		}
+		t[name] = merge(t[name], value)
+	}
+	return t
+}
+
+func apply(doc interface{}, op Operation) (interface{}, error) {
+	var (
+		value interface{}
+		err   error
+	)
+	switch op.Op {
+	case "add", "replace", "test":
+		if len(op.Value) == 0 {
+			return nil, errors.New("patch operation " + op.Op + " needs value")
+		}
+		if err = json.Unmarshal(op.Value, &value); err != nil {
+			return nil, err
+		}
+	case "move", "copy":
+		value, err = get(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+	case "remove":
+	default:
+		return nil, errors.New("patch operation not recognized: " + op.Op)
+	}
+
+	switch op.Op {
+	case "add":
+		return set(doc, op.Path, value, true)
+	case "replace":
+		if _, err = get(doc, op.Path); err != nil {
+			return nil, err
+		}
+		return set(doc, op.Path, value, false)
+	case "remove":
+		return remove(doc, op.Path)
+	case "move":
+		if strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, errors.New("patch can not move value into its own child")
+		}
+		if doc, err = remove(doc, op.From); err != nil {
+			return nil, err
+		}
+		return set(doc, op.Path, value, true)
+	case "copy":
+		return set(doc, op.Path, clone(value), true)
+	}
+	// test
+	current, err := get(doc, op.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !reflect.DeepEqual(current, value) {
+		return nil, ErrTestFailed
+	}
+	return doc, nil
+}
+
+// clone deeply copies decoded JSON value
+func clone(value interface{}) interface{} {
+	switch node := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(node))
+		for name, v := range node {
+			m[name] = clone(v)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(node))
+		for i, v := range node {
+			a[i] = clone(v)
+		}
+		return a
+	}
+	return value
+}
+
+// split parses JSON Pointer (RFC 6901) into tokens
+func split(pointer string) ([]string, error) {
+	if len(pointer) == 0 {
+		return nil, nil
+	}
+	if pointer[0] != '/' {
+		return nil, errors.New("patch path must start with /: " + pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+func get(doc interface{}, pointer string) (interface{}, error) {
+	tokens, err := split(pointer)
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range tokens {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, ErrBadPath
+			}
+			doc = value
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, ErrBadPath
+			}
+			doc = node[i]
+		default:
+			return nil, ErrBadPath
+		}
+	}
+	return doc, nil
+}
+
+// set puts value at pointer, inserting it into array if insert is set
+// and replacing array element otherwise
+func set(doc interface{}, pointer string, value interface{}, insert bool) (interface{}, error) {
+	tokens, err := split(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	parentPointer := pointer[:strings.LastIndex(pointer, "/")]
+	parent, err := get(doc, parentPointer)
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+		return doc, nil
+	case []interface{}:
+		i := len(node)
+		if last != "-" {
+			i, err = strconv.Atoi(last)
+			if err != nil || i < 0 || i > len(node) || (!insert && i == len(node)) {
+				return nil, ErrBadPath
+			}
+		}
+		if !insert {
+			node[i] = value
+			return doc, nil
+		}
+		node = append(node, nil)
+		copy(node[i+1:], node[i:])
+		node[i] = value
+		return set(doc, parentPointer, node, false)
+	}
+	return nil, ErrBadPath
+}
+
+func remove(doc interface{}, pointer string) (interface{}, error) {
+	tokens, err := split(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("patch can not remove whole document")
+	}
+	parentPointer := pointer[:strings.LastIndex(pointer, "/")]
+	parent, err := get(doc, parentPointer)
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		if _, ok := node[last]; !ok {
+			return nil, ErrBadPath
+		}
+		delete(node, last)
+		return doc, nil
+	case []interface{}:
+		i, err := strconv.Atoi(last)
+		if err != nil || i < 0 || i >= len(node) {
+			return nil, ErrBadPath
+		}
+		node = append(node[:i], node[i+1:]...)
+		return set(doc, parentPointer, node, false)
+	}
+	return nil, ErrBadPath
+}
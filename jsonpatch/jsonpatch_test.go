@@ -0,0 +1,210 @@
+package jsonpatch_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/nilvxingren/echoxormdemo/jsonpatch"
+)
+
+// Cases of RFC 6902 Appendix A and a few more
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name, doc, patch, expected string
+	}{
+		{"add object member",
+			`{"foo":"bar"}`,
+			`[{"op":"add","path":"/baz","value":"qux"}]`,
+			`{"baz":"qux","foo":"bar"}`},
+		{"add array element",
+			`{"foo":["bar","baz"]}`,
+			`[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			`{"foo":["bar","qux","baz"]}`},
+		{"add to array end",
+			`{"foo":["bar"]}`,
+			`[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+			`{"foo":["bar",["abc","def"]]}`},
+		{"add to nested array",
+			`{"a":[{"b":[1]}]}`,
+			`[{"op":"add","path":"/a/0/b/0","value":0},{"op":"add","path":"/a/0/b/-","value":2}]`,
+			`{"a":[{"b":[0,1,2]}]}`},
+		{"add replaces existing member",
+			`{"foo":"bar"}`,
+			`[{"op":"add","path":"/foo","value":null}]`,
+			`{"foo":null}`},
+		{"add whole document",
+			`{"foo":"bar"}`,
+			`[{"op":"add","path":"","value":[1]}]`,
+			`[1]`},
+		{"remove object member",
+			`{"baz":"qux","foo":"bar"}`,
+			`[{"op":"remove","path":"/baz"}]`,
+			`{"foo":"bar"}`},
+		{"remove array element",
+			`{"foo":["bar","qux","baz"]}`,
+			`[{"op":"remove","path":"/foo/1"}]`,
+			`{"foo":["bar","baz"]}`},
+		{"remove from root array",
+			`[1,2,3]`,
+			`[{"op":"remove","path":"/0"}]`,
+			`[2,3]`},
+		{"replace value",
+			`{"baz":"qux","foo":"bar"}`,
+			`[{"op":"replace","path":"/baz","value":"boo"}]`,
+			`{"baz":"boo","foo":"bar"}`},
+		{"replace array element",
+			`{"foo":[1,2]}`,
+			`[{"op":"replace","path":"/foo/1","value":3}]`,
+			`{"foo":[1,3]}`},
+		{"move value",
+			`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{"move array element",
+			`{"foo":["all","grass","cows","eat"]}`,
+			`[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			`{"foo":["all","cows","eat","grass"]}`},
+		{"copy value",
+			`{"a":{"b":[1]}}`,
+			`[{"op":"copy","from":"/a","path":"/c"},{"op":"add","path":"/c/b/-","value":2}]`,
+			`{"a":{"b":[1]},"c":{"b":[1,2]}}`},
+		{"test value",
+			`{"baz":"qux","foo":["a",2,"c"]}`,
+			`[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`},
+		{"escaped path",
+			`{"/":9,"~1":10}`,
+			`[{"op":"test","path":"/~01","value":10},{"op":"replace","path":"/~1","value":8},{"op":"add","path":"/a~0b","value":1}]`,
+			`{"/":8,"~1":10,"a~b":1}`},
+		{"empty key",
+			`{"":1}`,
+			`[{"op":"replace","path":"/","value":2}]`,
+			`{"":2}`},
+	}
+	for _, tt := range tests {
+		result, err := jsonpatch.Apply([]byte(tt.doc), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		assertJSON(t, tt.name, result, tt.expected)
+	}
+}
+
+func TestApplyFails(t *testing.T) {
+	tests := []struct {
+		name, doc, patch string
+		err              error
+	}{
+		{"test of other value",
+			`{"baz":"qux"}`,
+			`[{"op":"test","path":"/baz","value":"bar"}]`,
+			jsonpatch.ErrTestFailed},
+		{"test of string and number",
+			`{"/":9}`,
+			`[{"op":"test","path":"/~1","value":"9"}]`,
+			jsonpatch.ErrTestFailed},
+		{"test before add",
+			`{"a":1}`,
+			`[{"op":"add","path":"/b","value":2},{"op":"test","path":"/a","value":2}]`,
+			jsonpatch.ErrTestFailed},
+		{"add to missing parent",
+			`{"foo":"bar"}`,
+			`[{"op":"add","path":"/baz/bat","value":"qux"}]`,
+			jsonpatch.ErrBadPath},
+		{"add past array end",
+			`{"foo":[1]}`,
+			`[{"op":"add","path":"/foo/2","value":2}]`,
+			jsonpatch.ErrBadPath},
+		{"remove missing member",
+			`{"foo":"bar"}`,
+			`[{"op":"remove","path":"/baz"}]`,
+			jsonpatch.ErrBadPath},
+		{"replace missing member",
+			`{"foo":"bar"}`,
+			`[{"op":"replace","path":"/baz","value":1}]`,
+			jsonpatch.ErrBadPath},
+		{"replace array end",
+			`{"foo":[1]}`,
+			`[{"op":"replace","path":"/foo/-","value":2}]`,
+			jsonpatch.ErrBadPath},
+		{"move from missing member",
+			`{"foo":"bar"}`,
+			`[{"op":"move","from":"/baz","path":"/qux"}]`,
+			jsonpatch.ErrBadPath},
+		{"bad array index",
+			`{"foo":[1]}`,
+			`[{"op":"remove","path":"/foo/01x"}]`,
+			jsonpatch.ErrBadPath},
+	}
+	for _, tt := range tests {
+		_, err := jsonpatch.Apply([]byte(tt.doc), []byte(tt.patch))
+		if err != tt.err {
+			t.Errorf("%s: got error %v, expected %v", tt.name, err, tt.err)
+		}
+	}
+
+	for _, patch := range []string{
+		`[{"op":"increment","path":"/a"}]`,
+		`[{"op":"add","path":"/a"}]`,
+		`[{"op":"add","path":"a","value":1}]`,
+		`[{"op":"move","from":"/a","path":"/a/b"}]`,
+		`[{"op":"remove","path":""}]`,
+		`{"op":"add","path":"/a","value":1}`,
+	} {
+		if _, err := jsonpatch.Apply([]byte(`{"a":{}}`), []byte(patch)); err == nil {
+			t.Errorf("%s: no error", patch)
+		}
+	}
+}
+
+// Cases of RFC 7396 Appendix A
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		doc, patch, expected string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		result, err := jsonpatch.MergePatch([]byte(tt.doc), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("%s + %s: %v", tt.doc, tt.patch, err)
+			continue
+		}
+		assertJSON(t, tt.doc+" + "+tt.patch, result, tt.expected)
+	}
+
+	if _, err := jsonpatch.MergePatch([]byte(`{}`), []byte(`{`)); err == nil {
+		t.Error("malformed patch applied")
+	}
+}
+
+//------------------------------------------------------------------------------
+func assertJSON(t *testing.T, name string, actual []byte, expected string) {
+	var a, e interface{}
+	if err := json.Unmarshal(actual, &a); err != nil {
+		t.Errorf("%s: %v", name, err)
+		return
+	}
+	if err := json.Unmarshal([]byte(expected), &e); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if !reflect.DeepEqual(a, e) {
+		t.Errorf("%s: got %s, expected %s", name, actual, expected)
+	}
+}
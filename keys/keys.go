@@ -0,0 +1,230 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// DefaultKID is kid of HMAC key made of secret when no keys are configured
+const DefaultKID = "default"
+
+// Config describes one key of key set
+type Config struct {
+	KID            string `toml:"kid"`
+	Algorithm      string `toml:"algorithm"`        // if empty then algorithm of key set is used
+	PrivateKeyFile string `toml:"private_key_file"` // key without private part can verify only
+	PublicKeyFile  string `toml:"public_key_file"`  // may be omitted if private key is given
+}
+
+// Key is a JWT signing and/or verification key
+type Key struct {
+	KID    string
+	Method jwt.SigningMethod
+	Signer interface{} // nil if key can verify only
+	Verify interface{}
+}
+
+// KeySet holds keys used to sign tokens (current one) and to verify them
+// (current and previous ones, so that keys can be rotated without
+// invalidating issued tokens)
+type KeySet struct {
+	current *Key
+	keys    map[string]*Key
+	order   []string
+}
+
+// NewHMACKeySet constructs key set of single HS256 key made of secret
+func NewHMACKeySet(secret string) (*KeySet, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("empty JWT secret")
+	}
+	ks := newKeySet()
+	key := &Key{
+		KID:    DefaultKID,
+		Method: jwt.SigningMethodHS256,
+		Signer: []byte(secret),
+		Verify: []byte(secret),
+	}
+	ks.add(key)
+	ks.current = key
+	return ks, nil
+}
+
+// Load reads keys from PEM files. Token are signed with key signingKID,
+// or with the first key having private part if signingKID is empty
+func Load(algorithm, signingKID string, configs []Config) (*KeySet, error) {
+	ks := newKeySet()
+	for _, cfg := range configs {
+		if len(cfg.KID) == 0 {
+			return nil, errors.New("JWT key without kid")
+		}
+		if _, ok := ks.keys[cfg.KID]; ok {
+			return nil, errors.New("duplicate JWT key kid " + cfg.KID)
+		}
+		alg := cfg.Algorithm
+		if len(alg) == 0 {
+			alg = algorithm
+		}
+		key, err := loadKey(cfg, alg)
+		if err != nil {
+			return nil, fmt.Errorf("JWT key %s: %v", cfg.KID, err)
+		}
+		ks.add(key)
+		if ks.current == nil && key.Signer != nil && (len(signingKID) == 0 || signingKID == key.KID) {
+			ks.current = key
+		}
+	}
+	if ks.current == nil {
+		return nil, errors.New("no JWT signing key with private part " + signingKID)
+	}
+	return ks, nil
+}
+
+// Sign makes signed token with claims using current key
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.current.Method, claims)
+	token.Header["kid"] = ks.current.KID
+	return token.SignedString(ks.current.Signer)
+}
+
+// Parse verifies token with key from its kid header and returns it parsed
+func (ks *KeySet) Parse(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, ks.keyFunc)
+}
+
+// Keys returns all keys in configuration order
+func (ks *KeySet) Keys() []*Key {
+	keys := make([]*Key, 0, len(ks.order))
+	for _, kid := range ks.order {
+		keys = append(keys, ks.keys[kid])
+	}
+	return keys
+}
+
+//------------------------------------------------------------------------------
+func newKeySet() *KeySet {
+	ks := new(KeySet)
+	ks.keys = make(map[string]*Key)
+	return ks
+}
+
+func (ks *KeySet) add(key *Key) {
+	ks.keys[key.KID] = key
+	ks.order = append(ks.order, key.KID)
+}
+
+// keyFunc picks verification key, tokens without kid are verified with current key.
+// Token algorithm must match key algorithm to prevent algorithm substitution.
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	key := ks.current
+	if kid, ok := token.Header["kid"]; ok {
+		s, _ := kid.(string)
+		if key, ok = ks.keys[s]; !ok {
+			return nil, errors.New("unknown kid")
+		}
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, errors.New("unexpected signing method " + token.Method.Alg())
+	}
+	return key.Verify, nil
+}
+
+func loadKey(cfg Config, alg string) (*Key, error) {
+	var (
+		key = &Key{KID: cfg.KID}
+		err error
+	)
+	key.Method = jwt.GetSigningMethod(alg)
+	if key.Method == nil {
+		return nil, errors.New("unsupported algorithm " + alg)
+	}
+	if strings.HasPrefix(alg, "HS") {
+		return nil, errors.New("HMAC keys are configured with secret, not with files")
+	}
+
+	if len(cfg.PrivateKeyFile) != 0 {
+		key.Signer, err = readPrivateKey(cfg.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.Signer.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported private key type")
+		}
+		key.Verify = signer.Public()
+	}
+	if len(cfg.PublicKeyFile) != 0 {
+		key.Verify, err = readPublicKey(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if key.Verify == nil {
+		return nil, errors.New("neither private nor public key file given")
+	}
+
+	if !keyMatchesAlg(key.Verify, alg) {
+		return nil, fmt.Errorf("key of type %T can not be used with %s", key.Verify, alg)
+	}
+	return key, nil
+}
+
+func keyMatchesAlg(key interface{}, alg string) bool {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS")
+	case *ecdsa.PublicKey:
+		m, ok := jwt.GetSigningMethod(alg).(*jwt.SigningMethodECDSA)
+		return ok && m.CurveBits == k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return alg == SigningMethodEdDSA.Alg()
+	}
+	return false
+}
+
+func readPEM(fileName string) (*pem.Block, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data in " + fileName)
+	}
+	return block, nil
+}
+
+func readPrivateKey(fileName string) (interface{}, error) {
+	block, err := readPEM(fileName)
+	if err != nil {
+		return nil, err
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	return x509.ParsePKCS8PrivateKey(block.Bytes)
+}
+
+func readPublicKey(fileName string) (interface{}, error) {
+	block, err := readPEM(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
@@ -0,0 +1,284 @@
+package keys_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+
+	"github.com/nilvxingren/echoxormdemo/keys"
+)
+
+type keyCase struct {
+	alg    string
+	signer crypto.Signer
+}
+
+func keyCases(t *testing.T) []keyCase {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []keyCase{
+		{"RS256", rsaKey},
+		{"ES256", ecKey},
+		{"EdDSA", edKey},
+	}
+}
+
+func TestSignAndParse(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	for _, tc := range keyCases(t) {
+		private, _ := writeKey(t, dir, tc.alg, tc.signer)
+		ks, err := keys.Load(tc.alg, "", []keys.Config{{KID: tc.alg, PrivateKeyFile: private}})
+		if err != nil {
+			t.Fatalf("%s: load: %v", tc.alg, err)
+		}
+		signed, err := ks.Sign(jwt.MapClaims{"sub": "1"})
+		if err != nil {
+			t.Fatalf("%s: sign: %v", tc.alg, err)
+		}
+		token, err := ks.Parse(signed)
+		if err != nil || !token.Valid {
+			t.Fatalf("%s: parse: %v", tc.alg, err)
+		}
+		if token.Header["kid"] != tc.alg || token.Header["alg"] != tc.alg {
+			t.Errorf("%s: header %v", tc.alg, token.Header)
+		}
+		if sub := token.Claims.(jwt.MapClaims)["sub"]; sub != "1" {
+			t.Errorf("%s: sub %v", tc.alg, sub)
+		}
+		// signature of other token does not fit
+		parts := strings.Split(signed, ".")
+		other, _ := ks.Sign(jwt.MapClaims{"sub": "2"})
+		forged := parts[0] + "." + parts[1] + "." + strings.Split(other, ".")[2]
+		if _, err = ks.Parse(forged); err == nil {
+			t.Errorf("%s: forged token accepted", tc.alg)
+		}
+	}
+}
+
+func TestParseRejectsAlgorithmOfOtherKey(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	cases := keyCases(t)
+	rsaPrivate, rsaPublic := writeKey(t, dir, "rsa", cases[0].signer)
+	ecPrivate, _ := writeKey(t, dir, "ec", cases[1].signer)
+	ks, err := keys.Load("RS256", "rsa", []keys.Config{
+		{KID: "rsa", PrivateKeyFile: rsaPrivate},
+		{KID: "ec", Algorithm: "ES256", PrivateKeyFile: ecPrivate},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM, err := ioutil.ReadFile(rsaPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		sign func() (string, error)
+		err  string
+	}{
+		{"HS256 keyed by RSA public key", func() (string, error) {
+			return sign(jwt.SigningMethodHS256, "rsa", publicPEM)
+		}, "unexpected signing method HS256"},
+		{"ES256 with kid of RS256 key", func() (string, error) {
+			return sign(jwt.SigningMethodES256, "rsa", cases[1].signer)
+		}, "unexpected signing method ES256"},
+		{"RS256 with kid of ES256 key", func() (string, error) {
+			return sign(jwt.SigningMethodRS256, "ec", cases[0].signer)
+		}, "unexpected signing method RS256"},
+		{"none", func() (string, error) {
+			return sign(jwt.SigningMethodNone, "rsa", jwt.UnsafeAllowNoneSignatureType)
+		}, "unexpected signing method none"},
+		{"unknown kid", func() (string, error) {
+			return sign(jwt.SigningMethodRS256, "other", cases[0].signer)
+		}, "unknown kid"},
+	}
+	for _, tt := range tests {
+		signed, err := tt.sign()
+		if err != nil {
+			t.Fatalf("%s: sign: %v", tt.name, err)
+		}
+		_, err = ks.Parse(signed)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got error %v, expected %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestRotation(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	cases := keyCases(t)
+	oldPrivate, oldPublic := writeKey(t, dir, "old", cases[1].signer)
+	newPrivate, _ := writeKey(t, dir, "new", cases[2].signer)
+
+	before, err := keys.Load("ES256", "", []keys.Config{{KID: "old", PrivateKeyFile: oldPrivate}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued, err := before.Sign(jwt.MapClaims{"sub": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// new key signs, old one only verifies
+	after, err := keys.Load("ES256", "new", []keys.Config{
+		{KID: "old", PublicKeyFile: oldPublic},
+		{KID: "new", Algorithm: "EdDSA", PrivateKeyFile: newPrivate},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err := after.Parse(issued); err != nil || !token.Valid {
+		t.Fatalf("token of old key: %v", err)
+	}
+	signed, err := after.Sign(jwt.MapClaims{"sub": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := after.Parse(signed)
+	if err != nil || token.Header["kid"] != "new" {
+		t.Fatalf("token of new key: %v %v", err, token)
+	}
+	if after.Keys()[0].Signer != nil {
+		t.Error("public only key can sign")
+	}
+
+	// key without private part can not be the signing one
+	_, err = keys.Load("ES256", "old", []keys.Config{{KID: "old", PublicKeyFile: oldPublic}})
+	if err == nil {
+		t.Error("public only key loaded for signing")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	var configs []keys.Config
+	cases := keyCases(t)
+	for _, tc := range cases {
+		private, _ := writeKey(t, dir, tc.alg, tc.signer)
+		configs = append(configs, keys.Config{KID: tc.alg, Algorithm: tc.alg, PrivateKeyFile: private})
+	}
+	ks, err := keys.Load("", "", configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := ks.JWKS()
+	if len(set.Keys) != len(cases) {
+		t.Fatalf("%d keys published", len(set.Keys))
+	}
+
+	rsaKey := cases[0].signer.Public().(*rsa.PublicKey)
+	ecKey := cases[1].signer.Public().(*ecdsa.PublicKey)
+	edKey := cases[2].signer.Public().(ed25519.PublicKey)
+	tests := []struct {
+		jwk      keys.JWK
+		kty, crv string
+		fields   map[string][]byte
+	}{
+		{set.Keys[0], "RSA", "", map[string][]byte{
+			"n": rsaKey.N.Bytes(),
+			"e": big.NewInt(int64(rsaKey.E)).Bytes(),
+		}},
+		{set.Keys[1], "EC", "P-256", map[string][]byte{
+			"x": ecKey.X.FillBytes(make([]byte, 32)),
+			"y": ecKey.Y.FillBytes(make([]byte, 32)),
+		}},
+		{set.Keys[2], "OKP", "Ed25519", map[string][]byte{
+			"x": edKey,
+		}},
+	}
+	for i, tt := range tests {
+		alg := cases[i].alg
+		if tt.jwk.Kid != alg || tt.jwk.Alg != alg || tt.jwk.Use != "sig" || tt.jwk.Kty != tt.kty || tt.jwk.Crv != tt.crv {
+			t.Errorf("%s: %+v", alg, tt.jwk)
+		}
+		values := map[string]string{"n": tt.jwk.N, "e": tt.jwk.E, "x": tt.jwk.X, "y": tt.jwk.Y}
+		for name, value := range values {
+			expected, ok := tt.fields[name]
+			if !ok {
+				if len(value) != 0 {
+					t.Errorf("%s: unexpected %s", alg, name)
+				}
+				continue
+			}
+			if value != base64.RawURLEncoding.EncodeToString(expected) {
+				t.Errorf("%s: %s is %s", alg, name, value)
+			}
+		}
+	}
+
+	hmac, err := keys.NewHMACKeySet("jwt-super-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hmac.JWKS().Keys) != 0 {
+		t.Error("HMAC key published")
+	}
+}
+
+//------------------------------------------------------------------------------
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "echo-xorm-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// writeKey writes PKCS #8 private and PKIX public PEM files of key
+func writeKey(t *testing.T, dir, name string, key crypto.Signer) (string, string) {
+	private, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateFile := filepath.Join(dir, name+".pem")
+	publicFile := filepath.Join(dir, name+".pub.pem")
+	err = ioutil.WriteFile(privateFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: private}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(publicFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privateFile, publicFile
+}
+
+// sign makes token with given method and kid bypassing key set
+func sign(method jwt.SigningMethod, kid string, key interface{}) (string, error) {
+	token := jwt.NewWithClaims(method, jwt.MapClaims{"sub": "1"})
+	token.Header["kid"] = kid
+	return token.SignedString(key)
+}
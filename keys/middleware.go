@@ -0,0 +1,31 @@
+package keys
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// ContextKey is a name under which verified *jwt.Token is stored in echo.Context
+const ContextKey = "user"
+
+// JWT returns a middleware that verifies bearer token of request with key set
+// and stores it in context under ContextKey
+func JWT(ks *KeySet) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			auth := c.Request().Header.Get(echo.HeaderAuthorization)
+			const prefix = "Bearer "
+			if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+				return echo.NewHTTPError(http.StatusBadRequest, "missing or malformed jwt")
+			}
+			token, err := ks.Parse(auth[len(prefix):])
+			if err != nil || !token.Valid {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired jwt")
+			}
+			c.Set(ContextKey, token)
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package mailer
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nilvxingren/echoxormdemo/logger"
+)
+
+// FileMailer appends messages to file instead of sending them
+type FileMailer struct {
+	mu       sync.Mutex
+	fileName string
+	from     string
+}
+
+// NewFileMailer is a constructor
+func NewFileMailer(fileName, from string) *FileMailer {
+	m := new(FileMailer)
+	m.fileName = fileName
+	m.from = from
+	return m
+}
+
+// Send implements Mailer
+func (m *FileMailer) Send(to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString("Date: " + time.Now().UTC().Format(time.RFC1123Z) + "\r\n" + compose(m.from, to, subject, body) + "\r\n")
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LogMailer writes messages to application log instead of sending them
+type LogMailer struct {
+	logger logger.Logger
+	from   string
+}
+
+// NewLogMailer is a constructor
+func NewLogMailer(l logger.Logger, from string) *LogMailer {
+	m := new(LogMailer)
+	m.logger = l
+	m.from = from
+	return m
+}
+
+// Send implements Mailer
+func (m *LogMailer) Send(to, subject, body string) error {
+	m.logger.Info("mail", compose(m.from, to, subject, body))
+	return nil
+}
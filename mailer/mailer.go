@@ -0,0 +1,22 @@
+package mailer
+
+import (
+	"strings"
+)
+
+// Mailer is an interface for sending e-mail messages
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// compose makes RFC 5322 message, header values are stripped of line breaks
+func compose(from, to, subject, body string) string {
+	clean := strings.NewReplacer("\r", "", "\n", "")
+	return "From: " + clean.Replace(from) + "\r\n" +
+		"To: " + clean.Replace(to) + "\r\n" +
+		"Subject: " + clean.Replace(subject) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		strings.Replace(body, "\n", "\r\n", -1) + "\r\n"
+}
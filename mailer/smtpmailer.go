@@ -0,0 +1,34 @@
+package mailer
+
+import (
+	"errors"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// SMTPMailer sends messages through SMTP server
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer is a constructor, no authentication is made if username is empty
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	m := new(SMTPMailer)
+	m.addr = net.JoinHostPort(host, port)
+	m.from = from
+	if len(username) != 0 {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+// Send implements Mailer
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	if strings.ContainsAny(to, "\r\n") {
+		return errors.New("invalid recipient address")
+	}
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{to}, []byte(compose(m.from, to, subject, body)))
+}
@@ -0,0 +1,276 @@
+// Package migrations keeps database schema versioned. Migrations are ordered Go
+// functions, applied ones are recorded in schema_migrations table. Migrator
+// holds a lock in database while it works, so that instances do not migrate at once
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-xorm/core"
+	"github.com/go-xorm/xorm"
+
+	"github.com/nilvxingren/echoxormdemo/logger"
+)
+
+// StaleLockAge is an age of lock which is considered left by crashed instance
+const StaleLockAge = 10 * time.Minute
+
+// Errors of migrator
+var (
+	ErrLocked         = errors.New("migrations are locked by another instance")
+	ErrUnknownVersion = errors.New("unknown migration version")
+	ErrNoDown         = errors.New("migration can not be reverted")
+)
+
+// Migration is a versioned change of schema. Up and Down run in transaction
+// where dialect allows (DDL commits implicitly in MySQL). Tables of Adopt
+// entities which exist already, synced by application before migrations,
+// get missing columns and indexes before Up
+type Migration struct {
+	Version int64
+	Name    string
+	Adopt   []interface{}
+	Up      func(s *xorm.Session) error
+	Down    func(s *xorm.Session) error
+}
+
+// Record is an applied migration (here are DB definitions)
+type Record struct {
+	Version int64  `xorm:"'version' pk notnull"`
+	Name    string `xorm:"varchar(255) not null 'name'"`
+	Applied uint64 `xorm:"'applied' not null"`
+}
+
+// TableName used by xorm to set table name for entity
+func (r *Record) TableName() string {
+	return "schema_migrations"
+}
+
+// Lock is a row held by migrating instance
+type Lock struct {
+	ID     int64  `xorm:"'id' pk notnull"`
+	Owner  string `xorm:"varchar(255) not null 'owner'"`
+	Locked uint64 `xorm:"'locked' not null"`
+}
+
+// TableName used by xorm to set table name for entity
+func (l *Lock) TableName() string {
+	return "schema_migrations_lock"
+}
+
+// Status is a state of one migration
+type Status struct {
+	Version int64  `json:"version"`
+	Name    string `json:"name"`
+	Applied uint64 `json:"applied"` // unix time, 0 if pending
+}
+
+// Migrator applies and reverts migrations
+type Migrator struct {
+	orm        *xorm.Engine
+	log        logger.Logger
+	migrations []Migration
+	owner      string
+}
+
+// New constructor, migrations must be ordered by version
+func New(orm *xorm.Engine, log logger.Logger, migrations []Migration) *Migrator {
+	host, _ := os.Hostname()
+	return &Migrator{
+		orm:        orm,
+		log:        log,
+		migrations: migrations,
+		owner:      host + ":" + strconv.Itoa(os.Getpid()),
+	}
+}
+
+// Up applies every pending migration
+func (m *Migrator) Up() error {
+	if len(m.migrations) == 0 {
+		return nil
+	}
+	return m.To(m.migrations[len(m.migrations)-1].Version)
+}
+
+// Down reverts the last applied migration
+func (m *Migrator) Down() error {
+	return m.locked(func() error {
+		applied, err := m.applied()
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			if _, ok := applied[m.migrations[i].Version]; ok {
+				return m.down(m.migrations[i])
+			}
+		}
+		return nil
+	})
+}
+
+// To applies or reverts migrations so that version is the last applied one,
+// version 0 reverts all of them
+func (m *Migrator) To(version int64) error {
+	if version != 0 && m.index(version) < 0 {
+		return ErrUnknownVersion
+	}
+	return m.locked(func() error {
+		applied, err := m.applied()
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			mg := m.migrations[i]
+			if _, ok := applied[mg.Version]; ok && mg.Version > version {
+				if err = m.down(mg); err != nil {
+					return err
+				}
+			}
+		}
+		for _, mg := range m.migrations {
+			if _, ok := applied[mg.Version]; !ok && mg.Version <= version {
+				if err = m.up(mg); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Status lists migrations with time they are applied at. Schema is only read,
+// none of migrations is applied if there is no table of records
+func (m *Migrator) Status() ([]Status, error) {
+	exists, err := m.orm.IsTableExist(new(Record))
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int64]uint64{}
+	if exists {
+		if applied, err = m.applied(); err != nil {
+			return nil, err
+		}
+	}
+	status := make([]Status, len(m.migrations))
+	for i, mg := range m.migrations {
+		status[i] = Status{Version: mg.Version, Name: mg.Name, Applied: applied[mg.Version]}
+	}
+	return status, nil
+}
+
+//------------------------------------------------------------------------------
+// locked runs f holding migrations lock
+func (m *Migrator) locked(f func() error) error {
+	if err := m.orm.Sync2(new(Record), new(Lock)); err != nil {
+		return err
+	}
+	now := uint64(time.Now().UTC().Unix())
+	// lock of crashed instance would block migrations forever
+	stale := now - uint64(StaleLockAge.Seconds())
+	if _, err := m.orm.Where("locked < ?", stale).Delete(&Lock{}); err != nil {
+		return err
+	}
+	// primary key lets one instance only insert the lock row
+	if _, err := m.orm.InsertOne(&Lock{ID: 1, Owner: m.owner, Locked: now}); err != nil {
+		// other errors than the taken lock row are not hidden behind ErrLocked
+		if taken, getErr := m.orm.Get(&Lock{ID: 1}); getErr == nil && taken {
+			return ErrLocked
+		}
+		return err
+	}
+	defer func() {
+		if _, err := m.orm.Where("id = 1 AND owner = ?", m.owner).Delete(&Lock{}); err != nil {
+			m.log.Error("migrations", "lock release error: "+err.Error())
+		}
+	}()
+	return f()
+}
+
+// applied returns applied versions with time they are applied at
+func (m *Migrator) applied() (map[int64]uint64, error) {
+	var records []Record
+	if err := m.orm.Find(&records); err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]uint64, len(records))
+	for _, r := range records {
+		applied[r.Version] = r.Applied
+	}
+	return applied, nil
+}
+
+func (m *Migrator) up(mg Migration) error {
+	m.log.Info("migrations", fmt.Sprintf("applying %d %s", mg.Version, mg.Name))
+	if err := m.adopt(mg); err != nil {
+		return err
+	}
+	return m.run(mg, mg.Up, func(s *xorm.Session) error {
+		_, err := s.InsertOne(&Record{Version: mg.Version, Name: mg.Name, Applied: uint64(time.Now().UTC().Unix())})
+		return err
+	})
+}
+
+func (m *Migrator) down(mg Migration) error {
+	if mg.Down == nil {
+		return fmt.Errorf("%d %s: %v", mg.Version, mg.Name, ErrNoDown)
+	}
+	m.log.Info("migrations", fmt.Sprintf("reverting %d %s", mg.Version, mg.Name))
+	return m.run(mg, mg.Down, func(s *xorm.Session) error {
+		_, err := s.Where("version = ?", mg.Version).Delete(&Record{})
+		return err
+	})
+}
+
+// adopt syncs existing tables of migration entities. Sync runs on engine,
+// so it is done before transaction of migration is begun
+func (m *Migrator) adopt(mg Migration) error {
+	for _, bean := range mg.Adopt {
+		exists, err := m.orm.IsTableExist(bean)
+		if err == nil && exists {
+			err = m.orm.Sync2(bean)
+		}
+		if err != nil {
+			return fmt.Errorf("migration %d %s: %v", mg.Version, mg.Name, err)
+		}
+	}
+	return nil
+}
+
+// run runs change of migration and its record in one transaction where dialect allows
+func (m *Migrator) run(mg Migration, change, record func(s *xorm.Session) error) error {
+	session := m.orm.NewSession()
+	defer session.Close()
+	tx := m.orm.Dialect().DBType() != core.MYSQL
+	if tx {
+		if err := session.Begin(); err != nil {
+			return err
+		}
+	}
+	err := change(session)
+	if err == nil {
+		err = record(session)
+	}
+	if err != nil {
+		if tx {
+			session.Rollback()
+		}
+		return fmt.Errorf("migration %d %s: %v", mg.Version, mg.Name, err)
+	}
+	if tx {
+		return session.Commit()
+	}
+	return nil
+}
+
+func (m *Migrator) index(version int64) int {
+	for i, mg := range m.migrations {
+		if mg.Version == version {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,89 @@
+package migrations
+
+import (
+	"github.com/go-xorm/xorm"
+
+	"github.com/nilvxingren/echoxormdemo/server/apikeys"
+	"github.com/nilvxingren/echoxormdemo/server/audit"
+	"github.com/nilvxingren/echoxormdemo/server/auth"
+	"github.com/nilvxingren/echoxormdemo/server/groups"
+	"github.com/nilvxingren/echoxormdemo/server/users"
+)
+
+// All migrations of application, append new ones to the end. Initial tables
+// are created from entities; later changes of entities need migrations of
+// their own with explicit statements, since these ones are not run again.
+// Users table of databases synced before migrations lacks columns added
+// since, it is adopted
+var All = []Migration{
+	{
+		Version: 1,
+		Name:    "create users",
+		Adopt:   []interface{}{new(users.User)},
+		Up:      createTables(new(users.User)),
+		Down:    dropTables(new(users.User)),
+	},
+	{
+		Version: 2,
+		Name:    "create auth tokens",
+		Up:      createTables(new(auth.RefreshToken), new(auth.RevokedToken), new(auth.PasswordReset)),
+		Down:    dropTables(new(auth.RefreshToken), new(auth.RevokedToken), new(auth.PasswordReset)),
+	},
+	{
+		Version: 3,
+		Name:    "create api keys",
+		Up:      createTables(new(apikeys.APIKey)),
+		Down:    dropTables(new(apikeys.APIKey)),
+	},
+	{
+		Version: 4,
+		Name:    "create groups",
+		Up:      createTables(new(groups.Group), new(groups.Membership)),
+		Down:    dropTables(new(groups.Group), new(groups.Membership)),
+	},
+	{
+		Version: 5,
+		Name:    "create audit log",
+		Up:      createTables(new(audit.Entry)),
+		Down:    dropTables(new(audit.Entry)),
+	},
+}
+
+//------------------------------------------------------------------------------
+// createTables returns migration creating tables of entities with their indexes.
+// Existing tables are kept, so that databases synced before migrations are adopted
+func createTables(beans ...interface{}) func(s *xorm.Session) error {
+	return func(s *xorm.Session) error {
+		for _, bean := range beans {
+			exists, err := s.IsTableExist(bean)
+			if err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+			if err = s.CreateTable(bean); err != nil {
+				return err
+			}
+			if err = s.CreateIndexes(bean); err != nil {
+				return err
+			}
+			if err = s.CreateUniques(bean); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// dropTables returns migration dropping tables of entities in reverse order
+func dropTables(beans ...interface{}) func(s *xorm.Session) error {
+	return func(s *xorm.Session) error {
+		for i := len(beans) - 1; i >= 0; i-- {
+			if err := s.DropTable(beans[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
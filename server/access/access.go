@@ -0,0 +1,170 @@
+package access
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+
+	"github.com/nilvxingren/echoxormdemo/server/problem"
+)
+
+// Roles known to application
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// Scopes of limited tokens
+const (
+	ScopePasswordChange = "password_change" // issued for user with expired password
+	ScopeMFA            = "mfa"             // issued for the second step of login, allows no routes
+	ScopeUsersRead      = "users:read"      // may be granted to API keys, see apikeys.Input
+	ScopeUsersWrite     = "users:write"     // may be granted to API keys, see apikeys.Input
+)
+
+// scopeRoutes lists routes ("METHOD path") allowed for tokens limited by scope
+var scopeRoutes = map[string][]string{
+	ScopePasswordChange: {"POST /users/me/password"},
+	ScopeUsersRead:      {"GET /users", "GET /users/export", "GET /users/:id"},
+	ScopeUsersWrite:     {"POST /users", "POST /users/import", "PUT /users/:id", "PATCH /users/:id", "DELETE /users/:id", "POST /users/:id/restore"},
+}
+
+// Errors of access middlewares
+var (
+	ErrAccessDenied     = problem.Forbidden("access_denied", "access denied")
+	ErrScopeDenied      = problem.Forbidden("scope_denied", "token scope does not allow this request")
+	ErrAPIKeyNotAllowed = problem.Forbidden("api_key_not_allowed", "not allowed with API key")
+)
+
+// AdminOnly is a middleware that lets through requests of admins only
+func AdminOnly(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !IsAdmin(c) {
+			return ErrAccessDenied
+		}
+		return next(c)
+	}
+}
+
+// RestrictScope is a middleware that lets tokens limited by scopes (space separated)
+// through to the routes allowed for any of these scopes only. Tokens without scope are not limited
+func RestrictScope(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		scopes := strings.Fields(Scope(c))
+		if len(scopes) == 0 {
+			return next(c)
+		}
+		route := c.Request().Method + " " + c.Path()
+		for _, scope := range scopes {
+			for _, allowed := range scopeRoutes[scope] {
+				if route == allowed {
+					return next(c)
+				}
+			}
+		}
+		return ErrScopeDenied
+	}
+}
+
+// TokenOnly is a middleware that lets through requests authorized with
+// login token, not with API key
+func TokenOnly(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if APIKeyID(c) != 0 {
+			return ErrAPIKeyNotAllowed
+		}
+		return next(c)
+	}
+}
+
+// SelfOrAdmin returns a middleware that lets through requests of admins and
+// requests of user whose ID is in path parameter param
+func SelfOrAdmin(param string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if IsAdmin(c) {
+				return next(c)
+			}
+			id, err := strconv.ParseUint(c.Param(param), 10, 64)
+			if err != nil || id == 0 || id != UserID(c) {
+				return ErrAccessDenied
+			}
+			return next(c)
+		}
+	}
+}
+
+// IsAdmin checks if request is made by admin
+func IsAdmin(c echo.Context) bool {
+	return Role(c) == RoleAdmin
+}
+
+// Role returns role of request's user, tokens without role are of plain user
+func Role(c echo.Context) string {
+	role := ClaimString(Claims(c), "role")
+	if len(role) == 0 {
+		return RoleUser
+	}
+	return role
+}
+
+// Scope returns scope token of request is limited by, empty if token is not limited
+func Scope(c echo.Context) string {
+	return ClaimString(Claims(c), "scope")
+}
+
+// APIKeyID returns ID of API key request is authorized with, 0 for login token
+func APIKeyID(c echo.Context) uint64 {
+	return ClaimUint(Claims(c), "api_key")
+}
+
+// UserID returns ID of request's user or 0 if request is not authorized
+func UserID(c echo.Context) uint64 {
+	return ClaimUint(Claims(c), "sub")
+}
+
+// Claims returns claims of token verified by JWT middleware
+func Claims(c echo.Context) jwt.MapClaims {
+	token, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return nil
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil
+	}
+	return claims
+}
+
+// ClaimString reads string claim
+func ClaimString(claims jwt.MapClaims, name string) string {
+	s, _ := claims[name].(string)
+	return s
+}
+
+// ClaimUint reads numeric claim which may be encoded either as number or as string
+func ClaimUint(claims jwt.MapClaims, name string) uint64 {
+	switch v := claims[name].(type) {
+	case float64:
+		return uint64(v)
+	case string:
+		n, _ := strconv.ParseUint(v, 10, 64)
+		return n
+	}
+	return 0
+}
+
+// ClaimMillis reads time claim in milliseconds, fraction of second is kept
+func ClaimMillis(claims jwt.MapClaims, name string) uint64 {
+	switch v := claims[name].(type) {
+	case float64:
+		return uint64(math.Round(v * 1000))
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return uint64(math.Round(f * 1000))
+	}
+	return 0
+}
@@ -0,0 +1,159 @@
+package apikeys
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+
+	"github.com/nilvxingren/echoxormdemo/ctx"
+	"github.com/nilvxingren/echoxormdemo/keys"
+	"github.com/nilvxingren/echoxormdemo/server/access"
+	"github.com/nilvxingren/echoxormdemo/server/groups"
+	"github.com/nilvxingren/echoxormdemo/server/problem"
+	"github.com/nilvxingren/echoxormdemo/server/users"
+)
+
+// HeaderAPIKey is a request header API key is passed in
+const HeaderAPIKey = "X-API-Key"
+
+// ErrInvalidKey is an error of unknown, expired or orphaned API key
+var ErrInvalidKey = problem.New(http.StatusUnauthorized, "invalid_api_key", "invalid or expired API key")
+
+// Input represents payload data format
+type Input struct {
+	Name    string   `json:"name" validate:"required,max=64"`
+	Scopes  []string `json:"scopes" validate:"required,oneof=users:read users:write"` // key grants listed rights only
+	Expires uint64   `json:"expires"` // unix time, 0 means never
+}
+
+// Result represents response on API key creation, the only time key is shown
+type Result struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// Handler is a container for handlers and app data
+type Handler struct {
+	C *ctx.Context
+}
+
+// GetAPIKeys is a GET /users/me/api-keys handler
+func (h *Handler) GetAPIKeys(c echo.Context) error {
+	k := APIKey{UserID: access.UserID(c)}
+	apiKeys, err := k.FindAll(h.C.Orm)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, apiKeys)
+}
+
+// CreateAPIKey is a POST /users/me/api-keys handler
+func (h *Handler) CreateAPIKey(c echo.Context) error {
+	var (
+		input  Input
+		result Result
+		err    error
+	)
+
+	if err = c.Bind(&input); err != nil {
+		return problem.Bind(err)
+	}
+	if err = c.Validate(&input); err != nil {
+		return err
+	}
+	if input.Expires != 0 && input.Expires <= uint64(time.Now().UTC().Unix()) {
+		return problem.Validation("expires is in the past")
+	}
+
+	result.APIKey = APIKey{
+		UserID:  access.UserID(c),
+		Name:    input.Name,
+		Scopes:  strings.Join(input.Scopes, " "),
+		Expires: input.Expires,
+	}
+	result.Key, err = result.APIKey.Save(h.C.Orm)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, result)
+}
+
+// DeleteAPIKey is a DELETE /users/me/api-keys/{id} handler
+func (h *Handler) DeleteAPIKey(c echo.Context) error {
+	var (
+		k   APIKey
+		err error
+	)
+
+	k.ID, err = strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		return problem.BadRequest("id not recognized")
+	}
+	k.UserID = access.UserID(c)
+	if err = k.Delete(h.C.Orm); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// KeyOrJWT returns a middleware that authorizes request with API key from
+// X-API-Key header if there is one, and with jwtMiddleware otherwise.
+// API key is presented to further handlers as verified token of its user
+func (h *Handler) KeyOrJWT(jwtMiddleware echo.MiddlewareFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		withJWT := jwtMiddleware(next)
+		return func(c echo.Context) error {
+			key := c.Request().Header.Get(HeaderAPIKey)
+			if len(key) == 0 {
+				return withJWT(c)
+			}
+
+			var (
+				k    APIKey
+				user users.User
+			)
+			err := k.FindByKey(h.C.Orm, key)
+			if err != nil {
+				if err == ErrNotFound {
+					return ErrInvalidKey
+				}
+				return err
+			}
+			// key without scopes would grant every right of user
+			if k.IsExpired() || len(k.Scopes) == 0 {
+				return ErrInvalidKey
+			}
+			user.ID = k.UserID
+			err = user.Find(h.C.Orm)
+			if err != nil {
+				if err == users.ErrNotFound {
+					return ErrInvalidKey
+				}
+				return err
+			}
+			names, err := groups.NamesOf(h.C.Orm, user.ID)
+			if err != nil {
+				return err
+			}
+			if err = k.Touch(h.C.Orm); err != nil {
+				h.C.Logger.Error("apikeys", "API key use time saving error: "+err.Error())
+			}
+
+			claims := jwt.MapClaims{
+				"iat":     float64(time.Now().UTC().UnixNano()/int64(time.Millisecond)) / 1000,
+				"aud":     user.Login,
+				"sub":     strconv.FormatUint(user.ID, 10),
+				"role":    user.Role,
+				"groups":  names,
+				"scope":   k.Scopes,
+				"api_key": strconv.FormatUint(k.ID, 10),
+			}
+			c.Set(keys.ContextKey, &jwt.Token{Claims: claims, Valid: true})
+			return next(c)
+		}
+	}
+}
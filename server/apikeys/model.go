@@ -0,0 +1,125 @@
+package apikeys
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-xorm/xorm"
+
+	"github.com/nilvxingren/echoxormdemo/server/problem"
+	"github.com/nilvxingren/echoxormdemo/utils"
+)
+
+// Errors of API keys model
+var (
+	ErrNotFound = problem.New(http.StatusNotFound, "api_key_not_found", "API key not found")
+	ErrNotSaved = problem.New(http.StatusUnprocessableEntity, "api_key_not_saved", "db refused to save API key")
+)
+
+// keyPrefix marks API keys so that they are easy to recognize (e.g. by secret scanners)
+const keyPrefix = "exk_"
+
+// APIKey is an entity (here are DB definitions).
+// Only hash of key is stored, key itself is shown to user once on creation
+type APIKey struct {
+	ID       uint64 `xorm:"'id' pk autoincr unique notnull" json:"id"`
+	UserID   uint64 `xorm:"'user_id' index not null" json:"user_id"`
+	Name     string `xorm:"text not null 'name'" json:"name"`
+	Prefix   string `xorm:"text not null 'prefix'" json:"prefix"` // first characters of key to tell keys apart
+	Hash     string `xorm:"text index not null unique 'hash'" json:"-"`
+	Scopes   string `xorm:"text 'scopes'" json:"scopes"` // space separated, key without scopes is refused
+	Expires  uint64 `xorm:"'expires'" json:"expires"`    // 0 means never
+	LastUsed uint64 `xorm:"'last_used'" json:"last_used"`
+	Created  uint64 `xorm:"created" json:"created"`
+}
+
+// TableName used by xorm to set table name for entity
+func (k *APIKey) TableName() string {
+	return "api_keys"
+}
+
+// FindAll API keys of user in database
+func (k *APIKey) FindAll(orm *xorm.Engine) ([]APIKey, error) {
+	var (
+		keys []APIKey
+		err  error
+	)
+	err = orm.Where("user_id = ?", k.UserID).Asc("id").Find(&keys)
+	if err != nil {
+		return nil, problem.DB(err)
+	}
+	return keys, nil
+}
+
+// FindByKey finds API key in database by its value
+func (k *APIKey) FindByKey(orm *xorm.Engine, key string) error {
+	if !strings.HasPrefix(key, keyPrefix) {
+		return ErrNotFound
+	}
+	found, err := orm.Where("hash = ?", utils.GetSHA3Hash(key)).Get(k)
+	if err != nil {
+		return problem.DB(err)
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Save generates new API key and saves its hash to database. Returns the key
+func (k *APIKey) Save(orm *xorm.Engine) (string, error) {
+	random, err := utils.GetRandomToken(32)
+	if err != nil {
+		return "", problem.Internal(err)
+	}
+	key := keyPrefix + random
+	k.Prefix = key[:len(keyPrefix)+6]
+	k.Hash = utils.GetSHA3Hash(key)
+	k.Created = uint64(time.Now().UTC().Unix())
+	affected, err := orm.InsertOne(k)
+	if err != nil {
+		return "", problem.DB(err)
+	}
+	if affected == 0 {
+		return "", ErrNotSaved
+	}
+	return key, nil
+}
+
+// Delete API key of user from database
+func (k *APIKey) Delete(orm *xorm.Engine) error {
+	affected, err := orm.Where("id = ? AND user_id = ?", k.ID, k.UserID).Delete(&APIKey{})
+	if err != nil {
+		return problem.DB(err)
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteUserKeys deletes every API key of user
+func DeleteUserKeys(orm *xorm.Engine, userID uint64) error {
+	_, err := orm.Where("user_id = ?", userID).Delete(&APIKey{})
+	if err != nil {
+		return problem.DB(err)
+	}
+	return nil
+}
+
+// Touch stores time of key use, at most once a minute
+func (k *APIKey) Touch(orm *xorm.Engine) error {
+	now := uint64(time.Now().UTC().Unix())
+	if now-k.LastUsed < 60 {
+		return nil
+	}
+	k.LastUsed = now
+	_, err := orm.ID(k.ID).Cols("last_used").Update(k)
+	return err
+}
+
+// IsExpired reports whether API key can not be used anymore by time
+func (k *APIKey) IsExpired() bool {
+	return k.Expires != 0 && uint64(time.Now().UTC().Unix()) >= k.Expires
+}
@@ -0,0 +1,40 @@
+package audit
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+
+	"github.com/nilvxingren/echoxormdemo/ctx"
+)
+
+// Handler is a container for handlers and app data
+type Handler struct {
+	C *ctx.Context
+}
+
+// ListResult represents response on audit log request
+type ListResult struct {
+	Total  int64   `json:"total"`
+	Limit  int     `json:"limit"`
+	Offset int     `json:"offset"`
+	Items  []Entry `json:"items"`
+}
+
+// GetAudit is a GET /audit handler
+func (h *Handler) GetAudit(c echo.Context) error {
+	query, err := ParseQuery(c.QueryParams())
+	if err != nil {
+		return err
+	}
+	entries, total, err := query.Find(h.C.Orm)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, ListResult{
+		Total:  total,
+		Limit:  query.Limit,
+		Offset: query.Offset,
+		Items:  entries,
+	})
+}
@@ -0,0 +1,53 @@
+package audit
+
+import (
+	"reflect"
+	"strings"
+)
+
+// TagName is a struct tag that tunes Diff: "-" skips field, "redact" reports
+// change of field without values. Fields hidden from JSON are skipped unless redacted
+const TagName = "audit"
+
+// Change is an old and a new value of field, values of redacted field are null
+type Change struct {
+	Old      interface{} `json:"old"`
+	New      interface{} `json:"new"`
+	Redacted bool        `json:"redacted,omitempty"`
+}
+
+// Diff returns changes of fields between two values of the same struct type
+// (or pointers to them), fields are named as in JSON or in lower case if hidden from it
+func Diff(before, after interface{}) map[string]Change {
+	b := reflect.Indirect(reflect.ValueOf(before))
+	a := reflect.Indirect(reflect.ValueOf(after))
+	if b.Kind() != reflect.Struct || a.Type() != b.Type() {
+		return nil
+	}
+	changes := map[string]Change{}
+	t := b.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if len(sf.PkgPath) != 0 {
+			continue
+		}
+		tag := sf.Tag.Get(TagName)
+		name := strings.Split(sf.Tag.Get("json"), ",")[0]
+		if tag == "-" || (name == "-" && tag != "redact") {
+			continue
+		}
+		if len(name) == 0 || name == "-" {
+			name = strings.ToLower(sf.Name)
+		}
+		old, cur := b.Field(i).Interface(), a.Field(i).Interface()
+		if reflect.DeepEqual(old, cur) {
+			continue
+		}
+		if tag == "redact" {
+			changes[name] = Change{Redacted: true}
+			continue
+		}
+		changes[name] = Change{Old: old, New: cur}
+	}
+	return changes
+}
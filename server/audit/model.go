@@ -0,0 +1,223 @@
+package audit
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-xorm/xorm"
+	"github.com/labstack/echo"
+
+	"github.com/nilvxingren/echoxormdemo/ctx"
+	"github.com/nilvxingren/echoxormdemo/server/access"
+	"github.com/nilvxingren/echoxormdemo/server/problem"
+)
+
+// Actions of entries
+const (
+	ActionUserCreate  = "user.create"
+	ActionUserUpdate  = "user.update"
+	ActionUserDelete  = "user.delete"
+	ActionUserRestore = "user.restore"
+	ActionLogin       = "auth.login"
+
+	ActionPasswordReset  = "auth.password_reset"
+	ActionTOTPEnroll     = "auth.totp_enroll"
+	ActionTOTPConfirm    = "auth.totp_confirm"
+	ActionSessionsRevoke = "auth.sessions_revoke"
+)
+
+// Outcomes of entries
+const (
+	OutcomeSuccess     = "success"
+	OutcomeFailure     = "failure"
+	OutcomeMFARequired = "mfa_required" // password is right, second factor is not passed yet
+)
+
+// Limits of page size
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+// Entry is a record of audit log, entries are appended only
+type Entry struct {
+	ID       uint64            `xorm:"'id' pk autoincr unique notnull" json:"id"`
+	ActorID  uint64            `xorm:"'actor_id' index" json:"actor_id"` // 0 for anonymous request
+	APIKeyID uint64            `xorm:"'api_key_id'" json:"api_key_id,omitempty"`
+	Action   string            `xorm:"varchar(32) index not null 'action'" json:"action"`
+	Target   string            `xorm:"varchar(128) index 'target'" json:"target"`
+	Outcome  string            `xorm:"varchar(16) not null 'outcome'" json:"outcome"`
+	Reason   string            `xorm:"text 'reason'" json:"reason,omitempty"` // problem code of failure
+	Changes  map[string]Change `xorm:"text json 'changes'" json:"changes,omitempty"`
+	IP       string            `xorm:"varchar(64) 'ip'" json:"ip"`
+	Created  uint64            `xorm:"created index" json:"created"`
+}
+
+// TableName used by xorm to set table name for entity
+func (e *Entry) TableName() string {
+	return "audit_log"
+}
+
+// New returns successful entry of request, actor is taken from token of request
+func New(c echo.Context, action, target string) *Entry {
+	return &Entry{
+		ActorID:  access.UserID(c),
+		APIKeyID: access.APIKeyID(c),
+		Action:   action,
+		Target:   target,
+		Outcome:  OutcomeSuccess,
+		IP:       c.RealIP(),
+	}
+}
+
+// Command returns successful entry of operator command, it has no actor and IP
+func Command(action, target string) *Entry {
+	return &Entry{Action: action, Target: target, Outcome: OutcomeSuccess}
+}
+
+// UserTarget returns target of user entries
+func UserTarget(id uint64) string {
+	return "user:" + strconv.FormatUint(id, 10)
+}
+
+// LoginTarget returns target of login attempts of unknown user
+func LoginTarget(login string) string {
+	return "login:" + login
+}
+
+// Fail marks entry failed, reason is a problem code of err if there is one
+func (e *Entry) Fail(err error) *Entry {
+	e.Outcome = OutcomeFailure
+	if p, ok := err.(*problem.Problem); ok {
+		e.Reason = p.Code
+	} else if err != nil {
+		e.Reason = err.Error()
+	}
+	return e
+}
+
+// WithChanges sets field-level changes of entry
+func (e *Entry) WithChanges(changes map[string]Change) *Entry {
+	if len(changes) != 0 {
+		e.Changes = changes
+	}
+	return e
+}
+
+// Save appends entry to database
+func (e *Entry) Save(orm *xorm.Engine) error {
+	e.Created = uint64(time.Now().UTC().Unix())
+	if _, err := orm.InsertOne(e); err != nil {
+		return problem.DB(err)
+	}
+	return nil
+}
+
+// Record saves entries of request. Request does not fail with audit, errors
+// are written to application log only
+func Record(c *ctx.Context, entries ...*Entry) {
+	for _, e := range entries {
+		if err := e.Save(c.Orm); err != nil {
+			c.Logger.Error("audit", "entry "+e.Action+" of "+e.Target+" is not saved: "+err.Error())
+		}
+	}
+}
+
+// Query describes a page of audit log, newest entries go first
+type Query struct {
+	Limit  int
+	Offset int
+
+	ActorID uint64
+	Action  string
+	Target  string
+	Outcome string
+	From    uint64
+	To      uint64
+}
+
+// ParseQuery reads query from URL parameters:
+// limit, offset, actor_id, action, target, outcome, from, to (unix time, inclusive)
+func ParseQuery(params url.Values) (*Query, error) {
+	var err error
+	q := &Query{Limit: DefaultLimit}
+
+	if v := params.Get("limit"); len(v) != 0 {
+		q.Limit, err = strconv.Atoi(v)
+		if err != nil || q.Limit <= 0 || q.Limit > MaxLimit {
+			return nil, problem.Validation("limit must be in 1.." + strconv.Itoa(MaxLimit))
+		}
+	}
+	if v := params.Get("offset"); len(v) != 0 {
+		q.Offset, err = strconv.Atoi(v)
+		if err != nil || q.Offset < 0 {
+			return nil, problem.Validation("offset must be non-negative integer")
+		}
+	}
+
+	q.Action = params.Get("action")
+	q.Target = params.Get("target")
+	q.Outcome = params.Get("outcome")
+	for name, dst := range map[string]*uint64{
+		"actor_id": &q.ActorID,
+		"from":     &q.From,
+		"to":       &q.To,
+	} {
+		if v := params.Get(name); len(v) != 0 {
+			*dst, err = strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return nil, problem.Validation(name + " must be non-negative integer")
+			}
+		}
+	}
+	return q, nil
+}
+
+// Find returns page of entries and total number of entries matching filters
+func (q *Query) Find(orm *xorm.Engine) ([]Entry, int64, error) {
+	entries := []Entry{}
+	cond, args := q.filter()
+	total, err := orm.Where(cond, args...).Count(&Entry{})
+	if err != nil {
+		return nil, 0, problem.DB(err)
+	}
+	err = orm.Where(cond, args...).Desc("id").Limit(q.Limit, q.Offset).Find(&entries)
+	if err != nil {
+		return nil, 0, problem.DB(err)
+	}
+	return entries, total, nil
+}
+
+//------------------------------------------------------------------------------
+// filter builds WHERE condition of filters
+func (q *Query) filter() (string, []interface{}) {
+	conds := []string{"1 = 1"}
+	args := []interface{}{}
+	if q.ActorID != 0 {
+		conds = append(conds, "actor_id = ?")
+		args = append(args, q.ActorID)
+	}
+	if len(q.Action) != 0 {
+		conds = append(conds, "action = ?")
+		args = append(args, q.Action)
+	}
+	if len(q.Target) != 0 {
+		conds = append(conds, "target = ?")
+		args = append(args, q.Target)
+	}
+	if len(q.Outcome) != 0 {
+		conds = append(conds, "outcome = ?")
+		args = append(args, q.Outcome)
+	}
+	if q.From != 0 {
+		conds = append(conds, "created >= ?")
+		args = append(args, q.From)
+	}
+	if q.To != 0 {
+		conds = append(conds, "created <= ?")
+		args = append(args, q.To)
+	}
+	return "(" + strings.Join(conds, " AND ") + ")", args
+}
@@ -1,35 +1,82 @@
 package auth
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-xorm/xorm"
 	"github.com/labstack/echo"
+	"golang.org/x/crypto/bcrypt"
 
 	"github.com/nilvxingren/echoxormdemo/ctx"
+	"github.com/nilvxingren/echoxormdemo/server/access"
+	"github.com/nilvxingren/echoxormdemo/server/apikeys"
+	"github.com/nilvxingren/echoxormdemo/server/audit"
+	"github.com/nilvxingren/echoxormdemo/server/groups"
+	"github.com/nilvxingren/echoxormdemo/server/problem"
 	"github.com/nilvxingren/echoxormdemo/server/users"
+	"github.com/nilvxingren/echoxormdemo/utils"
 )
 
 // Handler represents handlers for '/auth'
 type Handler struct {
-	C   *ctx.Context
-	Key []byte
+	C            *ctx.Context
+	Lockout      *Lockout
+	IPLimiter    *RateLimiter
+	LoginLimiter *RateLimiter
 }
 
+// dummyHash is compared against when login is unknown, so that unknown login
+// and wrong password take the same time to answer
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+// Errors of auth handlers
+var (
+	ErrInvalidCredentials  = problem.New(http.StatusUnauthorized, "invalid_credentials", "invalid credentials")
+	ErrInvalidRefreshToken = ErrRefreshTokenNotFound
+	ErrNoJTI               = problem.BadRequest("token has no jti")
+)
+
 // Input represents payload data format
 type Input struct {
-	Login    string `json:"login"`
-	Password string `json:"password"`
+	Login    string `json:"login" validate:"required,max=32"`
+	Password string `json:"password" validate:"required,max=72"`
+}
+
+// RefreshInput represents payload data format of /auth/refresh
+type RefreshInput struct {
+	RefreshToken string `json:"refresh_token" validate:"max=128"`
+}
+
+// ResetInput represents payload data format of /auth/password-reset
+type ResetInput struct {
+	Login string `json:"login" validate:"max=32"`
+	Email string `json:"email" validate:"omitempty,max=254,email"`
+}
+
+// ResetConfirmInput represents payload data format of /auth/password-reset/confirm
+type ResetConfirmInput struct {
+	Token       string `json:"token" validate:"required,max=128"`
+	NewPassword string `json:"new_password" validate:"required,min=8,max=72,password"`
+}
+
+// DeleteMeInput represents payload data format of own account deletion
+type DeleteMeInput struct {
+	Password string `json:"password" validate:"required,max=72"`
 }
 
 // Result represents payload response format
 type Result struct {
-	Result string `json:"result"`
-	Token  string `json:"token"`
+	Result       string `json:"result"`
+	Token        string `json:"token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
 }
 
-// PostAuth is handler for /auth
+// PostAuth is handler for /auth, every attempt is recorded to audit log
 func (h *Handler) PostAuth(c echo.Context) error {
 	var (
 		input Input
@@ -38,41 +85,477 @@ func (h *Handler) PostAuth(c echo.Context) error {
 	)
 
 	if err = c.Bind(&input); err != nil {
-		return c.String(http.StatusBadRequest, err.Error())
+		return problem.Bind(err)
+	}
+	if err = c.Validate(&input); err != nil {
+		return err
+	}
+
+	entry := audit.New(c, audit.ActionLogin, audit.LoginTarget(input.Login))
+	// throttle login before doing any work
+	if ok, wait := h.LoginLimiter.Allow(input.Login); !ok {
+		return h.loginFailed(c, entry, tooManyRequests(c, wait))
+	}
+	// refuse locked out logins
+	if locked, left := h.Lockout.Locked(input.Login); locked {
+		return h.loginFailed(c, entry, lockedOut(c, left))
 	}
 
-	// find user
+	// find user (empty login would match any row)
 	user = users.User{Login: input.Login}
-	_, err = user.Find(h.C.Orm)
-	if err != nil {
-		return c.String(http.StatusUnauthorized, err.Error())
+	err = users.ErrNotFound
+	if len(input.Login) != 0 {
+		err = user.Find(h.C.Orm)
+	}
+	if err != nil && err != users.ErrNotFound {
+		return err
+	}
+	found := err == nil
+	if found {
+		entry.Target = audit.UserTarget(user.ID)
 	}
 
 	//validate user credentials
-	//err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password))
-	//if err != nil {
-	//	return c.String(http.StatusForbidden, "invalid credentials")
-	//}
+	hash := dummyHash
+	if found {
+		hash = []byte(user.Password)
+	}
+	err = bcrypt.CompareHashAndPassword(hash, []byte(input.Password))
+	if err != nil || !found {
+		h.Lockout.Fail(input.Login)
+		return h.loginFailed(c, entry, ErrInvalidCredentials)
+	}
+	entry.ActorID = user.ID
 
-	//create a HMAC SHA256 signer
-	token := jwt.New(jwt.SigningMethodHS256)
+	// second factor is required, failures are not forgotten until it is passed
+	if user.TOTPEnabled {
+		resp, err := h.issueMFAToken(&user)
+		if err != nil {
+			return err
+		}
+		entry.Outcome = audit.OutcomeMFARequired
+		audit.Record(h.C, entry)
+		return c.JSON(http.StatusOK, resp)
+	}
+	h.Lockout.Reset(input.Login)
 
-	//set claims
-	claims := token.Claims.(jwt.MapClaims)
-	claims["iss"] = "corvinusz/echo-xorm"
-	claims["iat"] = time.Now().UTC().Unix()
-	claims["exp"] = time.Now().Add(time.Hour * 72).UTC().Unix()
-	claims["aud"] = input.Login
-	claims["jti"] = user.ID
+	resp, err := h.issueUserTokens(&user)
+	if err != nil {
+		return err
+	}
+	audit.Record(h.C, entry)
+	return c.JSON(http.StatusOK, resp)
+}
+
+// PostRefresh is handler for /auth/refresh.
+// Refresh token is rotated on every use, reuse of rotated token revokes its whole family
+func (h *Handler) PostRefresh(c echo.Context) error {
+	var (
+		input RefreshInput
+		rt    RefreshToken
+		user  users.User
+		err   error
+	)
+
+	if err = c.Bind(&input); err != nil {
+		return problem.Bind(err)
+	}
+	if err = c.Validate(&input); err != nil {
+		return err
+	}
+	if len(input.RefreshToken) == 0 {
+		return ErrInvalidRefreshToken
+	}
+
+	// find refresh token
+	if err = rt.FindByToken(h.C.Orm, input.RefreshToken); err != nil {
+		return err
+	}
+	if rt.Revoked || rt.IsExpired() {
+		return ErrInvalidRefreshToken
+	}
 
-	t, err := token.SignedString(h.Key)
+	// rotate; token that has been rotated already is being reused
+	err = rt.Rotate(h.C.Orm)
 	if err != nil {
-		return c.String(http.StatusServiceUnavailable, "Error while signing the token:"+err.Error())
+		if err != ErrRefreshTokenRotated {
+			return err
+		}
+		h.C.Logger.Warn("auth", "refresh token reuse detected, revoking family of user "+strconv.FormatUint(rt.UserID, 10))
+		if err = rt.RevokeFamily(h.C.Orm); err != nil {
+			return err
+		}
+		return ErrInvalidRefreshToken
+	}
+
+	// find token owner
+	user.ID = rt.UserID
+	err = user.Find(h.C.Orm)
+	if err != nil {
+		if err == users.ErrNotFound {
+			return ErrInvalidRefreshToken
+		}
+		return err
+	}
+
+	// refresh can not prolong expired password
+	if user.IsPasswordExpired() {
+		resp, err := h.issuePasswordChangeToken(&user)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, resp)
 	}
 
-	resp := Result{
-		Result: "OK",
-		Token:  t,
+	resp, err := h.issueTokens(&user, rt.Family)
+	if err != nil {
+		return err
 	}
 	return c.JSON(http.StatusOK, resp)
 }
+
+// PostLogout is handler for /auth/logout.
+// Revokes access token of request and refresh token family if refresh token is given
+func (h *Handler) PostLogout(c echo.Context) error {
+	var (
+		input RefreshInput
+		rt    RefreshToken
+		err   error
+	)
+
+	if err = c.Bind(&input); err != nil {
+		return problem.Bind(err)
+	}
+	if err = c.Validate(&input); err != nil {
+		return err
+	}
+
+	if err = h.RevokeToken(c); err != nil {
+		return err
+	}
+
+	// revoke refresh token family of the same user
+	if len(input.RefreshToken) != 0 {
+		err = rt.FindByToken(h.C.Orm, input.RefreshToken)
+		if err == nil && rt.UserID == access.UserID(c) {
+			err = rt.RevokeFamily(h.C.Orm)
+		}
+		if err != nil && err != ErrRefreshTokenNotFound {
+			return err
+		}
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// DeleteSessions is a DELETE /users/{id}/sessions handler.
+// Revokes every access and refresh token of user
+func (h *Handler) DeleteSessions(c echo.Context) error {
+	var (
+		user users.User
+		err  error
+	)
+
+	user.ID, err = strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		return problem.BadRequest("id not recognized")
+	}
+	if err = user.Find(h.C.Orm); err != nil {
+		return err
+	}
+
+	if err = h.RevokeSessions(user.ID); err != nil {
+		return err
+	}
+	audit.Record(h.C, audit.New(c, audit.ActionSessionsRevoke, audit.UserTarget(user.ID)))
+	return c.NoContent(http.StatusOK)
+}
+
+// DeleteMe is a DELETE /users/me handler. Deletes user of token softly once
+// password is confirmed and revokes every session of user
+func (h *Handler) DeleteMe(c echo.Context) error {
+	var (
+		input DeleteMeInput
+		user  users.User
+		err   error
+	)
+
+	if err = c.Bind(&input); err != nil {
+		return problem.Bind(err)
+	}
+	if err = c.Validate(&input); err != nil {
+		return err
+	}
+	user.ID = access.UserID(c)
+	if user.ID == 0 {
+		return echo.ErrUnauthorized
+	}
+	if err = user.Find(h.C.Orm); err != nil {
+		return err
+	}
+	if !user.CheckPassword(input.Password) {
+		return users.ErrPasswordMismatch
+	}
+
+	if err = user.Delete(h.C.Orm); err != nil {
+		return err
+	}
+	audit.Record(h.C, audit.New(c, audit.ActionUserDelete, audit.UserTarget(user.ID)))
+	if err = h.RevokeSessions(user.ID); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// PostPasswordReset is handler for /auth/password-reset.
+// Mails reset token to user found by login or email, email must be of one user only. Answer does not depend
+// on whether user exists, so it can not be used to find out logins
+func (h *Handler) PostPasswordReset(c echo.Context) error {
+	var (
+		input ResetInput
+		user  users.User
+		err   error
+	)
+
+	if err = c.Bind(&input); err != nil {
+		return problem.Bind(err)
+	}
+	if err = c.Validate(&input); err != nil {
+		return err
+	}
+	if len(input.Login) == 0 && len(input.Email) == 0 {
+		return problem.Validation("login or email not recognized")
+	}
+	if ok, wait := h.LoginLimiter.Allow(input.Login + "\x00" + input.Email); !ok {
+		return tooManyRequests(c, wait)
+	}
+
+	if len(input.Login) != 0 {
+		user.Login = input.Login
+		err = user.Find(h.C.Orm)
+	} else {
+		// email shared by several users resets nobody's password
+		err = user.FindByEmail(h.C.Orm, input.Email)
+	}
+	if err != nil && err != users.ErrNotFound {
+		return err
+	}
+	if err == nil && len(user.Email) != 0 {
+		token, err := NewPasswordReset(h.C.Orm, user.ID, h.C.Config.Auth.PasswordResetTTL.Duration)
+		if err != nil {
+			return err
+		}
+		go h.sendPasswordReset(user, token)
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+// PostPasswordResetConfirm is handler for /auth/password-reset/confirm.
+// Sets new password and revokes all sessions of user
+func (h *Handler) PostPasswordResetConfirm(c echo.Context) error {
+	var (
+		input ResetConfirmInput
+		reset PasswordReset
+		user  users.User
+		err   error
+	)
+
+	if err = c.Bind(&input); err != nil {
+		return problem.Bind(err)
+	}
+	if err = c.Validate(&input); err != nil {
+		return err
+	}
+
+	// user is not known until token is consumed, guessed tokens are recorded too
+	entry := audit.New(c, audit.ActionPasswordReset, "")
+	if err = reset.Consume(h.C.Orm, input.Token); err != nil {
+		audit.Record(h.C, entry.Fail(err))
+		return err
+	}
+
+	user.ID = reset.UserID
+	entry.Target = audit.UserTarget(user.ID)
+	err = user.Find(h.C.Orm)
+	if err != nil {
+		if err == users.ErrNotFound {
+			err = ErrInvalidResetToken
+		}
+		audit.Record(h.C, entry.Fail(err))
+		return err
+	}
+	err = user.SetPassword(h.C.Orm, input.NewPassword, users.PasswordEtime(h.C.Config.Auth.PasswordLifetime.Duration))
+	if err != nil {
+		return err
+	}
+
+	// whoever had the old password must not stay logged in
+	if err = h.RevokeSessions(user.ID); err != nil {
+		return err
+	}
+	h.Lockout.Reset(user.Login)
+	audit.Record(h.C, entry)
+	return c.NoContent(http.StatusOK)
+}
+
+// GetJWKS is handler for /.well-known/jwks.json, publishes token verification keys
+func (h *Handler) GetJWKS(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.C.Keys.JWKS())
+}
+
+// IssueAccessToken creates access token of user without refresh token
+func (h *Handler) IssueAccessToken(user *users.User) (*Result, error) {
+	claims, err := h.newClaims(user)
+	if err != nil {
+		return nil, problem.Internal(err)
+	}
+	claims["role"] = user.Role
+	claims["groups"], err = groups.NamesOf(h.C.Orm, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return h.signClaims(claims, "OK")
+}
+
+// RevokeToken revokes access token of request
+func (h *Handler) RevokeToken(c echo.Context) error {
+	claims := access.Claims(c)
+	revoked := RevokedToken{
+		JTI:     access.ClaimString(claims, "jti"),
+		UserID:  access.ClaimUint(claims, "sub"),
+		Expires: access.ClaimUint(claims, "exp"),
+	}
+	if len(revoked.JTI) == 0 {
+		return ErrNoJTI
+	}
+	return revoked.Save(h.C.Orm)
+}
+
+// CheckLockout fails request of login locked out by failed password checks
+func (h *Handler) CheckLockout(c echo.Context, login string) error {
+	if locked, left := h.Lockout.Locked(login); locked {
+		return lockedOut(c, left)
+	}
+	return nil
+}
+
+// PasswordFailed counts failed password check of login towards lockout, so
+// that password can not be guessed by routes other than POST /auth either
+func (h *Handler) PasswordFailed(login string) {
+	h.Lockout.Fail(login)
+}
+
+// RevokeSessions revokes every access and refresh token of user and deletes
+// API keys of user, so that no credential issued before stays usable
+func (h *Handler) RevokeSessions(userID uint64) error {
+	// any access token issued so far expires in access token lifetime at most
+	revoked := RevokedToken{
+		UserID:  userID,
+		Expires: uint64(time.Now().Add(h.C.Config.Auth.AccessTokenTTL.Duration).UTC().Unix()),
+	}
+	if err := revoked.Save(h.C.Orm); err != nil {
+		return err
+	}
+	if err := RevokeUserTokens(h.C.Orm, userID); err != nil {
+		return err
+	}
+	return apikeys.DeleteUserKeys(h.C.Orm, userID)
+}
+
+// PurgeSessions deletes refresh tokens, denylist entries, password resets and
+// API keys of users purged in session s
+func (h *Handler) PurgeSessions(s *xorm.Session, userIDs []uint64) error {
+	for _, bean := range []interface{}{new(RefreshToken), new(RevokedToken), new(PasswordReset), new(apikeys.APIKey)} {
+		if _, err := s.In("user_id", userIDs).Delete(bean); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
+// loginFailed records failed login attempt to audit log and returns err
+func (h *Handler) loginFailed(c echo.Context, entry *audit.Entry, err error) error {
+	audit.Record(h.C, entry.Fail(err))
+	return err
+}
+
+// sendPasswordReset mails reset token to user, errors are logged only
+func (h *Handler) sendPasswordReset(user users.User, token string) {
+	body := "Hello, " + user.Login + "!\n\n" +
+		"Somebody (hopefully you) requested password reset.\n" +
+		"Your password reset token is:\n\n" + token + "\n\n"
+	if len(h.C.Config.Auth.PasswordResetURL) != 0 {
+		body += "Or follow the link:\n\n" + h.C.Config.Auth.PasswordResetURL + token + "\n\n"
+	}
+	body += "It expires in " + h.C.Config.Auth.PasswordResetTTL.Duration.String() + ".\n" +
+		"If you did not request password reset just ignore this message.\n"
+	err := h.C.Mailer.Send(user.Email, "Password reset", body)
+	if err != nil {
+		h.C.Logger.Error("auth", "password reset mail to user "+strconv.FormatUint(user.ID, 10)+" failed: "+err.Error())
+	}
+}
+
+// issueTokens creates access token and refresh token of family (new if empty) for user
+func (h *Handler) issueTokens(user *users.User, family string) (*Result, error) {
+	resp, err := h.IssueAccessToken(user)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := NewRefreshToken(h.C.Orm, user.ID, family, h.C.Config.Auth.RefreshTokenTTL.Duration)
+	if err != nil {
+		return nil, err
+	}
+	resp.RefreshToken = refreshToken
+	return resp, nil
+}
+
+// issueUserTokens creates tokens for authenticated user,
+// expired password may be used to change it only
+func (h *Handler) issueUserTokens(user *users.User) (*Result, error) {
+	if user.IsPasswordExpired() {
+		return h.issuePasswordChangeToken(user)
+	}
+	return h.issueTokens(user, "")
+}
+
+// issuePasswordChangeToken creates access token that allows to change password only
+func (h *Handler) issuePasswordChangeToken(user *users.User) (*Result, error) {
+	claims, err := h.newClaims(user)
+	if err != nil {
+		return nil, problem.Internal(err)
+	}
+	claims["scope"] = access.ScopePasswordChange
+	return h.signClaims(claims, "PASSWORD_EXPIRED")
+}
+
+// newClaims creates access token claims common for any token of user
+func (h *Handler) newClaims(user *users.User) (jwt.MapClaims, error) {
+	var (
+		err error
+		now = time.Now()
+	)
+	claims := jwt.MapClaims{}
+	claims["iss"] = "corvinusz/echo-xorm"
+	// fraction of second lets denylist tell tokens issued just before and after revocation
+	claims["iat"] = float64(now.UTC().UnixNano()/int64(time.Millisecond)) / 1000
+	claims["exp"] = now.Add(h.C.Config.Auth.AccessTokenTTL.Duration).UTC().Unix()
+	claims["aud"] = user.Login
+	claims["sub"] = strconv.FormatUint(user.ID, 10)
+	claims["jti"], err = utils.GetRandomToken(16)
+	return claims, err
+}
+
+// signClaims signs access token and wraps it into response
+func (h *Handler) signClaims(claims jwt.MapClaims, result string) (*Result, error) {
+	token, err := h.C.Keys.Sign(claims)
+	if err != nil {
+		return nil, problem.Internal(errors.New("Error while signing the token:" + err.Error()))
+	}
+	resp := &Result{
+		Result:    result,
+		Token:     token,
+		ExpiresIn: int64(h.C.Config.Auth.AccessTokenTTL.Duration / time.Second),
+	}
+	return resp, nil
+}
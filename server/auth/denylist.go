@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+
+	"github.com/nilvxingren/echoxormdemo/server/access"
+	"github.com/nilvxingren/echoxormdemo/server/problem"
+)
+
+// ErrTokenRevoked is an error of access token found in denylist
+var ErrTokenRevoked = problem.New(http.StatusUnauthorized, "token_revoked", "token revoked")
+
+// RejectRevoked is a middleware that rejects access tokens found in denylist.
+// It must follow JWT middleware which puts verified token to context
+func (h *Handler) RejectRevoked(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims := access.Claims(c)
+		if claims == nil {
+			return echo.ErrUnauthorized
+		}
+		revoked, err := IsRevoked(h.C.Orm, access.ClaimString(claims, "jti"), access.ClaimUint(claims, "sub"), access.ClaimMillis(claims, "iat"))
+		if err != nil {
+			return problem.DB(err)
+		}
+		if revoked {
+			return ErrTokenRevoked
+		}
+		return next(c)
+	}
+}
+
+// CleanupRevoked purges expired denylist entries every interval, never returns
+func (h *Handler) CleanupRevoked(interval time.Duration) {
+	for range time.Tick(interval) {
+		purged, err := PurgeRevoked(h.C.Orm)
+		if err != nil {
+			h.C.Logger.Error("auth", "revoked tokens cleanup error: "+err.Error())
+			continue
+		}
+		if purged != 0 {
+			h.C.Logger.Info("auth", "revoked tokens cleanup: "+strconv.FormatInt(purged, 10)+" purged")
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// Lockout tracks failed authentication attempts per login and blocks
+// further attempts for a while once too many of them have failed
+type Lockout struct {
+	mu          sync.Mutex
+	maxFailures int
+	duration    time.Duration
+	attempts    map[string]*attempt
+	lastPrune   time.Time
+}
+
+type attempt struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// NewLockout constructor
+func NewLockout(maxFailures int, duration time.Duration) *Lockout {
+	l := new(Lockout)
+	l.maxFailures = maxFailures
+	l.duration = duration
+	l.attempts = make(map[string]*attempt)
+	return l
+}
+
+// Locked reports whether login is locked and for how long it will stay locked
+func (l *Lockout) Locked(login string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, ok := l.attempts[login]
+	if !ok {
+		return false, 0
+	}
+	left := a.lockedUntil.Sub(time.Now())
+	if left <= 0 {
+		return false, 0
+	}
+	return true, left
+}
+
+// Fail registers failed attempt for login
+func (l *Lockout) Fail(login string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+	a, ok := l.attempts[login]
+	if !ok {
+		a = new(attempt)
+		l.attempts[login] = a
+	}
+	// failures older than lockout duration are forgotten
+	if now.Sub(a.lastFailure) > l.duration {
+		a.failures = 0
+	}
+	a.failures++
+	a.lastFailure = now
+	if a.failures >= l.maxFailures {
+		a.lockedUntil = now.Add(l.duration)
+		a.failures = 0
+	}
+}
+
+// Reset forgets failed attempts for login
+func (l *Lockout) Reset(login string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.attempts, login)
+}
+
+//------------------------------------------------------------------------------
+// prune drops records that can not affect any further attempt
+func (l *Lockout) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < l.duration {
+		return
+	}
+	l.lastPrune = now
+	for login, a := range l.attempts {
+		if now.After(a.lockedUntil) && now.Sub(a.lastFailure) > l.duration {
+			delete(l.attempts, login)
+		}
+	}
+}
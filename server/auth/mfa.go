@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+
+	"github.com/nilvxingren/echoxormdemo/keys"
+	"github.com/nilvxingren/echoxormdemo/server/access"
+	"github.com/nilvxingren/echoxormdemo/server/audit"
+	"github.com/nilvxingren/echoxormdemo/server/problem"
+	"github.com/nilvxingren/echoxormdemo/server/users"
+	"github.com/nilvxingren/echoxormdemo/totp"
+	"github.com/nilvxingren/echoxormdemo/utils"
+)
+
+// recoveryCodesCount is a number of one-time recovery codes given on enrollment
+const recoveryCodesCount = 10
+
+// mfaTokenTTL is a lifetime of token that allows the second step of login only
+const mfaTokenTTL = 5 * time.Minute
+
+// Errors of TOTP handlers
+var (
+	ErrTOTPEnabled     = problem.New(http.StatusConflict, "totp_already_enabled", "TOTP already enabled")
+	ErrTOTPNotEnrolled = problem.New(http.StatusBadRequest, "totp_not_enrolled", "TOTP enrollment not started")
+	ErrInvalidMFAToken = problem.New(http.StatusUnauthorized, "invalid_mfa_token", "invalid or expired mfa token")
+	ErrInvalidCode     = problem.New(http.StatusUnauthorized, "invalid_code", "invalid code")
+)
+
+// TOTPInput represents payload data format of TOTP code confirmation
+type TOTPInput struct {
+	MFAToken string `json:"mfa_token,omitempty" validate:"max=4096"`
+	Code     string `json:"code" validate:"required,max=64"`
+}
+
+// TOTPEnrollment represents response on TOTP enrollment
+type TOTPEnrollment struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// PostTOTPEnroll is a POST /users/me/totp handler.
+// Generates new secret and recovery codes, TOTP is not required until confirmed
+func (h *Handler) PostTOTPEnroll(c echo.Context) error {
+	var (
+		user   users.User
+		err    error
+		enroll TOTPEnrollment
+	)
+
+	user.ID = access.UserID(c)
+	if err = user.Find(h.C.Orm); err != nil {
+		return err
+	}
+	if user.TOTPEnabled {
+		return ErrTOTPEnabled
+	}
+
+	enroll.Secret, err = totp.GenerateSecret()
+	if err != nil {
+		return problem.Internal(err)
+	}
+	enroll.URI = totp.URI(h.C.Config.MFA.Issuer, user.Login, enroll.Secret)
+	hashes := make([]string, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		code, err := utils.GetRandomToken(8)
+		if err != nil {
+			return problem.Internal(err)
+		}
+		enroll.RecoveryCodes = append(enroll.RecoveryCodes, code)
+		hashes = append(hashes, utils.GetSHA3Hash(code))
+	}
+
+	user.TOTPSecret, err = utils.Encrypt(h.totpKey(), enroll.Secret)
+	if err != nil {
+		return problem.Internal(err)
+	}
+	user.TOTPLastStep = 0
+	user.TOTPRecovery = strings.Join(hashes, " ")
+	err = user.UpdateCols(h.C.Orm, "totp_secret", "totp_last_step", "totp_recovery")
+	if err != nil {
+		return err
+	}
+	audit.Record(h.C, audit.New(c, audit.ActionTOTPEnroll, audit.UserTarget(user.ID)))
+	return c.JSON(http.StatusOK, enroll)
+}
+
+// PostTOTPConfirm is a POST /users/me/totp/confirm handler.
+// Enables TOTP once user proves authenticator app is set up
+func (h *Handler) PostTOTPConfirm(c echo.Context) error {
+	var (
+		input TOTPInput
+		user  users.User
+		err   error
+	)
+
+	if err = c.Bind(&input); err != nil {
+		return problem.Bind(err)
+	}
+	if err = c.Validate(&input); err != nil {
+		return err
+	}
+	user.ID = access.UserID(c)
+	if err = user.Find(h.C.Orm); err != nil {
+		return err
+	}
+	if user.TOTPEnabled {
+		return ErrTOTPEnabled
+	}
+	if len(user.TOTPSecret) == 0 {
+		return ErrTOTPNotEnrolled
+	}
+
+	entry := audit.New(c, audit.ActionTOTPConfirm, audit.UserTarget(user.ID))
+	if err = h.checkTOTPCode(&user, input.Code, false); err != nil {
+		audit.Record(h.C, entry.Fail(err))
+		return err
+	}
+	user.TOTPEnabled = true
+	err = user.UpdateCols(h.C.Orm, "totp_enabled", "totp_last_step")
+	if err != nil {
+		return err
+	}
+	audit.Record(h.C, entry)
+	return c.JSON(http.StatusOK, user)
+}
+
+// PostAuthTOTP is handler for /auth/totp, the second step of login.
+// Exchanges token got from /auth and TOTP (or recovery) code for access token
+func (h *Handler) PostAuthTOTP(c echo.Context) error {
+	var (
+		input TOTPInput
+		user  users.User
+		err   error
+	)
+
+	if err = c.Bind(&input); err != nil {
+		return problem.Bind(err)
+	}
+	if err = c.Validate(&input); err != nil {
+		return err
+	}
+	token, err := h.C.Keys.Parse(input.MFAToken)
+	if err != nil || !token.Valid {
+		return ErrInvalidMFAToken
+	}
+	c.Set(keys.ContextKey, token)
+	if access.Scope(c) != access.ScopeMFA {
+		return ErrInvalidMFAToken
+	}
+
+	user.ID = access.UserID(c)
+	err = user.Find(h.C.Orm)
+	if err != nil {
+		if err == users.ErrNotFound {
+			return ErrInvalidMFAToken
+		}
+		return err
+	}
+	entry := audit.New(c, audit.ActionLogin, audit.UserTarget(user.ID))
+	if locked, left := h.Lockout.Locked(user.Login); locked {
+		return h.loginFailed(c, entry, lockedOut(c, left))
+	}
+
+	err = h.checkTOTPCode(&user, input.Code, true)
+	if err != nil {
+		if err == ErrInvalidCode {
+			h.Lockout.Fail(user.Login)
+			return h.loginFailed(c, entry, err)
+		}
+		return err
+	}
+	h.Lockout.Reset(user.Login)
+	err = user.UpdateCols(h.C.Orm, "totp_last_step", "totp_recovery")
+	if err != nil {
+		return err
+	}
+
+	resp, err := h.issueUserTokens(&user)
+	if err != nil {
+		return err
+	}
+	audit.Record(h.C, entry)
+	return c.JSON(http.StatusOK, resp)
+}
+
+//------------------------------------------------------------------------------
+// issueMFAToken creates token that allows the second step of login only
+func (h *Handler) issueMFAToken(user *users.User) (*Result, error) {
+	claims, err := h.newClaims(user)
+	if err != nil {
+		return nil, problem.Internal(err)
+	}
+	claims["scope"] = access.ScopeMFA
+	claims["exp"] = time.Now().Add(mfaTokenTTL).UTC().Unix()
+	resp, err := h.signClaims(claims, "MFA_REQUIRED")
+	if err != nil {
+		return nil, err
+	}
+	resp.ExpiresIn = int64(mfaTokenTTL / time.Second)
+	return resp, nil
+}
+
+// checkTOTPCode checks code against user secret and, if allowed, against
+// recovery codes. Used recovery code is removed from user (not saved)
+func (h *Handler) checkTOTPCode(user *users.User, code string, allowRecovery bool) error {
+	secret, err := utils.Decrypt(h.totpKey(), user.TOTPSecret)
+	if err != nil {
+		return problem.Internal(errors.New("TOTP secret decryption error: " + err.Error()))
+	}
+	code = strings.TrimSpace(code)
+	if step, ok := totp.Validate(secret, code, time.Now(), user.TOTPLastStep); ok {
+		user.TOTPLastStep = step
+		return nil
+	}
+	if allowRecovery {
+		hash := utils.GetSHA3Hash(code)
+		hashes := strings.Fields(user.TOTPRecovery)
+		for i := range hashes {
+			if hashes[i] == hash {
+				user.TOTPRecovery = strings.Join(append(hashes[:i], hashes[i+1:]...), " ")
+				return nil
+			}
+		}
+	}
+	return ErrInvalidCode
+}
+
+// totpKey returns AES key TOTP secrets are encrypted with
+func (h *Handler) totpKey() []byte {
+	key, err := base64.StdEncoding.DecodeString(h.C.Config.MFA.EncryptionKey)
+	if err == nil && len(key) == 32 {
+		return key
+	}
+	sum := sha256.Sum256([]byte("totp:" + h.C.Config.Secret))
+	return sum[:]
+}
@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-xorm/xorm"
+
+	"github.com/nilvxingren/echoxormdemo/server/problem"
+	"github.com/nilvxingren/echoxormdemo/utils"
+)
+
+// Errors of auth model
+var (
+	ErrRefreshTokenNotFound = problem.New(http.StatusUnauthorized, "invalid_refresh_token", "invalid refresh token")
+	ErrRefreshTokenRotated  = problem.New(http.StatusConflict, "refresh_token_rotated", "refresh token already rotated")
+	ErrInvalidResetToken    = problem.New(http.StatusBadRequest, "invalid_reset_token", "invalid or expired reset token")
+	ErrNotSaved             = problem.New(http.StatusUnprocessableEntity, "token_not_saved", "db refused to save token")
+)
+
+// RefreshToken is an entity (here are DB definitions).
+// Only hash of opaque token is stored, token itself is known to client only.
+// Every refresh token is descended from one login, all such tokens form a family.
+type RefreshToken struct {
+	ID      uint64 `xorm:"'id' pk autoincr unique notnull" json:"-"`
+	Hash    string `xorm:"text index not null unique 'hash'" json:"-"`
+	Family  string `xorm:"text index not null 'family'" json:"-"`
+	UserID  uint64 `xorm:"'user_id' index not null" json:"-"`
+	Expires uint64 `xorm:"'expires' not null" json:"-"`
+	Rotated uint64 `xorm:"'rotated'" json:"-"`
+	Revoked bool   `xorm:"'revoked'" json:"-"`
+	Created uint64 `xorm:"created" json:"-"`
+}
+
+// TableName used by xorm to set table name for entity
+func (t *RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+// NewRefreshToken generates token for user in family (new family if empty).
+// Returns opaque token to be passed to client
+func NewRefreshToken(orm *xorm.Engine, userID uint64, family string, ttl time.Duration) (string, error) {
+	var (
+		err   error
+		token string
+	)
+	if len(family) == 0 {
+		family, err = utils.GetRandomToken(16)
+		if err != nil {
+			return "", problem.Internal(err)
+		}
+	}
+	token, err = utils.GetRandomToken(32)
+	if err != nil {
+		return "", problem.Internal(err)
+	}
+	t := &RefreshToken{
+		Hash:    utils.GetSHA3Hash(token),
+		Family:  family,
+		UserID:  userID,
+		Expires: uint64(time.Now().Add(ttl).UTC().Unix()),
+	}
+	if err = t.Save(orm); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// FindByToken finds refresh token in database by its opaque value
+func (t *RefreshToken) FindByToken(orm *xorm.Engine, token string) error {
+	found, err := orm.Where("hash = ?", utils.GetSHA3Hash(token)).Get(t)
+	if err != nil {
+		return problem.DB(err)
+	}
+	if !found {
+		return ErrRefreshTokenNotFound
+	}
+	return nil
+}
+
+// Save refresh token to database
+func (t *RefreshToken) Save(orm *xorm.Engine) error {
+	affected, err := orm.InsertOne(t)
+	if err != nil {
+		return problem.DB(err)
+	}
+	if affected == 0 {
+		return ErrNotSaved
+	}
+	return nil
+}
+
+// Rotate marks refresh token as used. Fails with conflict if token
+// has been rotated already (i.e. concurrently)
+func (t *RefreshToken) Rotate(orm *xorm.Engine) error {
+	t.Rotated = uint64(time.Now().UTC().Unix())
+	affected, err := orm.ID(t.ID).Where("rotated = 0").Cols("rotated").Update(t)
+	if err != nil {
+		return problem.DB(err)
+	}
+	if affected == 0 {
+		return ErrRefreshTokenRotated
+	}
+	return nil
+}
+
+// RevokeFamily revokes every refresh token descended from the same login
+func (t *RefreshToken) RevokeFamily(orm *xorm.Engine) error {
+	_, err := orm.Where("family = ?", t.Family).Cols("revoked").Update(&RefreshToken{Revoked: true})
+	if err != nil {
+		return problem.DB(err)
+	}
+	return nil
+}
+
+// IsExpired reports whether refresh token can not be used anymore by time
+func (t *RefreshToken) IsExpired() bool {
+	return uint64(time.Now().UTC().Unix()) >= t.Expires
+}
+
+// RevokeUserTokens revokes every refresh token of user
+func RevokeUserTokens(orm *xorm.Engine, userID uint64) error {
+	_, err := orm.Where("user_id = ?", userID).Cols("revoked").Update(&RefreshToken{Revoked: true})
+	if err != nil {
+		return problem.DB(err)
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+// RevokedToken is an entity of access tokens denylist (here are DB definitions).
+// Entry with JTI revokes the only token, entry without JTI revokes
+// every token of user issued before entry was created. Created is in
+// milliseconds, so that user may log in again right after revocation.
+// Entry is useless once all tokens it revokes are expired.
+type RevokedToken struct {
+	ID      uint64 `xorm:"'id' pk autoincr unique notnull" json:"-"`
+	JTI     string `xorm:"text index 'jti'" json:"-"`
+	UserID  uint64 `xorm:"'user_id' index not null" json:"-"`
+	Expires uint64 `xorm:"'expires' index not null" json:"-"`
+	Created uint64 `xorm:"'created' not null" json:"-"` // milliseconds
+}
+
+// TableName used by xorm to set table name for entity
+func (t *RevokedToken) TableName() string {
+	return "revoked_tokens"
+}
+
+// Save revoked token to database
+func (t *RevokedToken) Save(orm *xorm.Engine) error {
+	t.Created = uint64(time.Now().UTC().UnixNano() / int64(time.Millisecond))
+	affected, err := orm.InsertOne(t)
+	if err != nil {
+		return problem.DB(err)
+	}
+	if affected == 0 {
+		return ErrNotSaved
+	}
+	return nil
+}
+
+// IsRevoked checks if access token with jti issued at iat (milliseconds) to user is denylisted
+func IsRevoked(orm *xorm.Engine, jti string, userID uint64, iat uint64) (bool, error) {
+	count, err := orm.
+		Where("jti = ? AND jti <> ''", jti).
+		Or("(jti IS NULL OR jti = '') AND user_id = ? AND created >= ?", userID, iat).
+		Count(&RevokedToken{})
+	if err != nil {
+		return false, err
+	}
+	return count != 0, nil
+}
+
+// PurgeRevoked deletes denylist entries which tokens are expired anyway
+func PurgeRevoked(orm *xorm.Engine) (int64, error) {
+	return orm.Where("expires < ?", time.Now().UTC().Unix()).Delete(&RevokedToken{})
+}
+
+//------------------------------------------------------------------------------
+
+// PasswordReset is an entity of password reset request (here are DB definitions).
+// Only hash of reset token is stored, token itself is sent to user by mail.
+type PasswordReset struct {
+	ID      uint64 `xorm:"'id' pk autoincr unique notnull" json:"-"`
+	Hash    string `xorm:"text index not null unique 'hash'" json:"-"`
+	UserID  uint64 `xorm:"'user_id' index not null" json:"-"`
+	Expires uint64 `xorm:"'expires' not null" json:"-"`
+	Used    uint64 `xorm:"'used'" json:"-"`
+	Created uint64 `xorm:"created" json:"-"`
+}
+
+// TableName used by xorm to set table name for entity
+func (r *PasswordReset) TableName() string {
+	return "password_resets"
+}
+
+// NewPasswordReset generates reset token for user, previous tokens of user become unusable.
+// Returns token to be sent to user
+func NewPasswordReset(orm *xorm.Engine, userID uint64, ttl time.Duration) (string, error) {
+	now := uint64(time.Now().UTC().Unix())
+	_, err := orm.Where("user_id = ? AND used = 0", userID).Cols("used").Update(&PasswordReset{Used: now})
+	if err != nil {
+		return "", problem.DB(err)
+	}
+	token, err := utils.GetRandomToken(32)
+	if err != nil {
+		return "", problem.Internal(err)
+	}
+	r := &PasswordReset{
+		Hash:    utils.GetSHA3Hash(token),
+		UserID:  userID,
+		Expires: uint64(time.Now().Add(ttl).UTC().Unix()),
+	}
+	affected, err := orm.InsertOne(r)
+	if err != nil {
+		return "", problem.DB(err)
+	}
+	if affected == 0 {
+		return "", ErrNotSaved
+	}
+	return token, nil
+}
+
+// Consume finds unused and unexpired reset by token and marks it used
+func (r *PasswordReset) Consume(orm *xorm.Engine, token string) error {
+	found, err := orm.Where("hash = ?", utils.GetSHA3Hash(token)).Get(r)
+	if err != nil {
+		return problem.DB(err)
+	}
+	now := uint64(time.Now().UTC().Unix())
+	if !found || r.Used != 0 || now >= r.Expires {
+		return ErrInvalidResetToken
+	}
+	r.Used = now
+	affected, err := orm.ID(r.ID).Where("used = 0").Cols("used").Update(r)
+	if err != nil {
+		return problem.DB(err)
+	}
+	if affected == 0 {
+		return ErrInvalidResetToken
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+
+	"github.com/nilvxingren/echoxormdemo/server/problem"
+)
+
+// RateLimiter is a token bucket rate limiter keyed by arbitrary string.
+// Every key may do burst requests at once, then requests are allowed at rate
+type RateLimiter struct {
+	mu        sync.Mutex
+	rate      float64 // tokens per second
+	burst     float64
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter constructor, limiter with non-positive perMinute allows everything
+func NewRateLimiter(perMinute, burst int) *RateLimiter {
+	l := new(RateLimiter)
+	l.rate = float64(perMinute) / 60
+	l.burst = float64(burst)
+	if l.burst < 1 {
+		l.burst = 1
+	}
+	l.buckets = make(map[string]*bucket)
+	return l
+}
+
+// Allow takes token from bucket of key. If there are no tokens it reports
+// how long to wait for the next one
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if l.rate <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refilled(b, now)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// RateLimitIP is a middleware that limits requests by client IP
+func (h *Handler) RateLimitIP(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if ok, wait := h.IPLimiter.Allow(c.RealIP()); !ok {
+			return tooManyRequests(c, wait)
+		}
+		return next(c)
+	}
+}
+
+//------------------------------------------------------------------------------
+func (l *RateLimiter) refilled(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.last).Seconds()*l.rate
+	if tokens > l.burst {
+		return l.burst
+	}
+	return tokens
+}
+
+// prune drops buckets that are full again, they are the same as absent ones
+func (l *RateLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+	for key, b := range l.buckets {
+		if l.refilled(b, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// tooManyRequests fails throttled request with 429 telling client when to retry
+func tooManyRequests(c echo.Context, wait time.Duration) error {
+	return problem.TooManyRequests(c, "rate_limited", "too many requests", int(wait/time.Second)+1)
+}
+
+// lockedOut fails login attempt with 429 telling client when lockout ends
+func lockedOut(c echo.Context, left time.Duration) error {
+	return problem.TooManyRequests(c, "login_locked", "too many failed login attempts", int(left/time.Second)+1)
+}
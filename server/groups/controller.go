@@ -0,0 +1,166 @@
+package groups
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo"
+
+	"github.com/nilvxingren/echoxormdemo/ctx"
+	"github.com/nilvxingren/echoxormdemo/server/problem"
+)
+
+// Input represents payload data format
+type Input struct {
+	Name        string `json:"name" validate:"required,min=2,max=64,name"`
+	Description string `json:"description" validate:"max=1024"`
+}
+
+// MemberInput represents payload data format of membership
+type MemberInput struct {
+	UserID uint64 `json:"user_id" validate:"required"`
+}
+
+// Handler is a container for handlers and app data
+type Handler struct {
+	C *ctx.Context
+}
+
+// GetAllGroups is a GET /groups handler
+func (h *Handler) GetAllGroups(c echo.Context) error {
+	var g Group
+	groups, err := g.FindAll(h.C.Orm)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, groups)
+}
+
+// GetGroup is a GET /groups/{id} handler
+func (h *Handler) GetGroup(c echo.Context) error {
+	g, err := h.find(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, g)
+}
+
+// CreateGroup is a POST /groups handler
+func (h *Handler) CreateGroup(c echo.Context) error {
+	var (
+		input Input
+		err   error
+	)
+
+	if err = c.Bind(&input); err != nil {
+		return problem.Bind(err)
+	}
+	if err = c.Validate(&input); err != nil {
+		return err
+	}
+	g := Group{Name: input.Name, Description: input.Description}
+	if err = g.Save(h.C.Orm); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, g)
+}
+
+// PutGroup is a PUT /groups/{id} handler
+func (h *Handler) PutGroup(c echo.Context) error {
+	var input Input
+
+	g, err := h.find(c)
+	if err != nil {
+		return err
+	}
+	if err = c.Bind(&input); err != nil {
+		return problem.Bind(err)
+	}
+	if err = c.Validate(&input); err != nil {
+		return err
+	}
+	g.Name = input.Name
+	g.Description = input.Description
+	if err = g.Update(h.C.Orm); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, g)
+}
+
+// DeleteGroup is a DELETE /groups/{id} handler, memberships are deleted too
+func (h *Handler) DeleteGroup(c echo.Context) error {
+	g, err := h.find(c)
+	if err != nil {
+		return err
+	}
+	if err = g.Delete(h.C.Orm); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// GetMembers is a GET /groups/{id}/members handler
+func (h *Handler) GetMembers(c echo.Context) error {
+	g, err := h.find(c)
+	if err != nil {
+		return err
+	}
+	members, err := g.Members(h.C.Orm)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, members)
+}
+
+// AddMember is a POST /groups/{id}/members handler
+func (h *Handler) AddMember(c echo.Context) error {
+	var input MemberInput
+
+	g, err := h.find(c)
+	if err != nil {
+		return err
+	}
+	if err = c.Bind(&input); err != nil {
+		return problem.Bind(err)
+	}
+	if err = c.Validate(&input); err != nil {
+		return err
+	}
+	if err = g.AddMember(h.C.Orm, input.UserID); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusCreated)
+}
+
+// RemoveMember is a DELETE /groups/{id}/members/{user_id} handler
+func (h *Handler) RemoveMember(c echo.Context) error {
+	g, err := h.find(c)
+	if err != nil {
+		return err
+	}
+	userID, err := strconv.ParseUint(c.Param("user_id"), 10, 0)
+	if err != nil {
+		return problem.BadRequest("user_id not recognized")
+	}
+	if err = g.RemoveMember(h.C.Orm, userID); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+//------------------------------------------------------------------------------
+// find finds group of id path parameter
+func (h *Handler) find(c echo.Context) (*Group, error) {
+	var (
+		g   Group
+		err error
+	)
+	g.ID, err = strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		return nil, problem.BadRequest("id not recognized")
+	}
+	if err = g.Find(h.C.Orm); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
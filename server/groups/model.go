@@ -0,0 +1,223 @@
+package groups
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-xorm/xorm"
+
+	"github.com/nilvxingren/echoxormdemo/server/problem"
+)
+
+// Errors of groups model
+var (
+	ErrNotFound       = problem.New(http.StatusNotFound, "group_not_found", "group not found")
+	ErrNameTaken      = problem.New(http.StatusConflict, "group_name_taken", "such group always exists")
+	ErrNotSaved       = problem.New(http.StatusUnprocessableEntity, "group_not_saved", "db refused to save group")
+	ErrUserNotFound   = problem.New(http.StatusNotFound, "user_not_found", "user not found")
+	ErrAlreadyMember  = problem.New(http.StatusConflict, "already_member", "user is a member of group already")
+	ErrMemberNotFound = problem.New(http.StatusNotFound, "member_not_found", "user is not a member of group")
+)
+
+// Group is an entity (here are DB definitions)
+type Group struct {
+	ID          uint64 `xorm:"'id' pk autoincr unique notnull" json:"id"`
+	Name        string `xorm:"text index not null unique 'name'" json:"name"`
+	Description string `xorm:"text 'description'" json:"description"`
+	Created     uint64 `xorm:"created" json:"created"`
+	Updated     uint64 `xorm:"updated" json:"updated"`
+}
+
+// TableName used by xorm to set table name for entity ("groups" is reserved by MySQL)
+func (g *Group) TableName() string {
+	return "user_groups"
+}
+
+// Membership is an entity of many-to-many relation of groups and users
+type Membership struct {
+	ID      uint64 `xorm:"'id' pk autoincr unique notnull" json:"-"`
+	GroupID uint64 `xorm:"'group_id' index not null unique(member)" json:"-"`
+	UserID  uint64 `xorm:"'user_id' index not null unique(member)" json:"-"`
+	Created uint64 `xorm:"created" json:"-"`
+}
+
+// TableName used by xorm to set table name for entity
+func (m *Membership) TableName() string {
+	return "group_members"
+}
+
+// Member is a user as a member of group, it is read from users table
+type Member struct {
+	ID    uint64 `xorm:"'id'" json:"id"`
+	Login string `xorm:"'login'" json:"login"`
+	Email string `xorm:"'email'" json:"email"`
+	Role  string `xorm:"'role'" json:"role"`
+}
+
+// FindAll groups in database
+func (g *Group) FindAll(orm *xorm.Engine) ([]Group, error) {
+	var groups []Group
+	if err := orm.Asc("name").Find(&groups); err != nil {
+		return nil, problem.DB(err)
+	}
+	return groups, nil
+}
+
+// Find group in database
+func (g *Group) Find(orm *xorm.Engine) error {
+	found, err := orm.Get(g)
+	if err != nil {
+		return problem.DB(err)
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Save group to database
+func (g *Group) Save(orm *xorm.Engine) error {
+	if err := g.CheckName(orm); err != nil {
+		return err
+	}
+	g.Created = uint64(time.Now().UTC().Unix())
+	g.Updated = g.Created
+	affected, err := orm.InsertOne(g)
+	if err != nil {
+		return problem.DB(err)
+	}
+	if affected == 0 {
+		return ErrNotSaved
+	}
+	return nil
+}
+
+// Update writes name and description of group found already to database
+func (g *Group) Update(orm *xorm.Engine) error {
+	if err := g.CheckName(orm); err != nil {
+		return err
+	}
+	g.Updated = uint64(time.Now().UTC().Unix())
+	if _, err := orm.ID(g.ID).Cols("name", "description", "updated").Update(g); err != nil {
+		return problem.DB(err)
+	}
+	return nil
+}
+
+// CheckName checks that name is not taken by another group
+func (g *Group) CheckName(orm *xorm.Engine) error {
+	count, err := orm.Where("name = ? AND id <> ?", g.Name, g.ID).Count(&Group{})
+	if err != nil {
+		return problem.DB(err)
+	}
+	if count != 0 {
+		return ErrNameTaken
+	}
+	return nil
+}
+
+// Delete group and its memberships from database
+func (g *Group) Delete(orm *xorm.Engine) error {
+	session := orm.NewSession()
+	defer session.Close()
+	if err := session.Begin(); err != nil {
+		return problem.DB(err)
+	}
+	if _, err := session.Where("group_id = ?", g.ID).Delete(&Membership{}); err != nil {
+		session.Rollback()
+		return problem.DB(err)
+	}
+	affected, err := session.ID(g.ID).Delete(&Group{})
+	if err != nil {
+		session.Rollback()
+		return problem.DB(err)
+	}
+	if affected == 0 {
+		session.Rollback()
+		return ErrNotFound
+	}
+	if err = session.Commit(); err != nil {
+		return problem.DB(err)
+	}
+	return nil
+}
+
+// Members of group, users deleted softly are not listed
+func (g *Group) Members(orm *xorm.Engine) ([]Member, error) {
+	var members []Member
+	err := orm.Table("users").
+		Select("users.id, users.login, users.email, users.role").
+		Join("INNER", "group_members", "group_members.user_id = users.id").
+		Where("group_members.group_id = ? AND users.deleted IS NULL", g.ID).
+		OrderBy("users.login").
+		Find(&members)
+	if err != nil {
+		return nil, problem.DB(err)
+	}
+	return members, nil
+}
+
+// AddMember adds user to group
+func (g *Group) AddMember(orm *xorm.Engine, userID uint64) error {
+	count, err := orm.Table("users").Where("id = ? AND deleted IS NULL", userID).Count(&Member{})
+	if err != nil {
+		return problem.DB(err)
+	}
+	if count == 0 {
+		return ErrUserNotFound
+	}
+	count, err = orm.Where("group_id = ? AND user_id = ?", g.ID, userID).Count(&Membership{})
+	if err != nil {
+		return problem.DB(err)
+	}
+	if count != 0 {
+		return ErrAlreadyMember
+	}
+	affected, err := orm.InsertOne(&Membership{GroupID: g.ID, UserID: userID})
+	if err != nil {
+		return problem.DB(err)
+	}
+	if affected == 0 {
+		return ErrNotSaved
+	}
+	return nil
+}
+
+// RemoveMember removes user from group
+func (g *Group) RemoveMember(orm *xorm.Engine, userID uint64) error {
+	affected, err := orm.Where("group_id = ? AND user_id = ?", g.ID, userID).Delete(&Membership{})
+	if err != nil {
+		return problem.DB(err)
+	}
+	if affected == 0 {
+		return ErrMemberNotFound
+	}
+	return nil
+}
+
+// OfUser returns groups user is a member of
+func OfUser(orm *xorm.Engine, userID uint64) ([]Group, error) {
+	groups := []Group{}
+	err := orm.Select("user_groups.*").
+		Join("INNER", "group_members", "group_members.group_id = user_groups.id").
+		Where("group_members.user_id = ?", userID).
+		OrderBy("user_groups.name").
+		Find(&groups)
+	if err != nil {
+		return nil, problem.DB(err)
+	}
+	return groups, nil
+}
+
+// NamesOf returns names of groups user is a member of
+func NamesOf(orm *xorm.Engine, userID uint64) ([]string, error) {
+	groups, err := OfUser(orm, userID)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(groups))
+	for i := range groups {
+		names[i] = groups[i].Name
+	}
+	return names, nil
+}
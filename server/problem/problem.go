@@ -0,0 +1,188 @@
+// Package problem defines errors of API and renders them as RFC 7807 problem details
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo"
+
+	"github.com/nilvxingren/echoxormdemo/logger"
+	"github.com/nilvxingren/echoxormdemo/validator"
+)
+
+// MIMEApplicationProblemJSON is a media type of problem details
+const MIMEApplicationProblemJSON = "application/problem+json"
+
+// Generic codes of problems. Codes are stable, clients may rely on them.
+// Packages define more specific codes next to their errors
+const (
+	CodeBadRequest           = "bad_request"
+	CodeValidation           = "validation_failed"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeConflict             = "conflict"
+	CodePreconditionFailed   = "precondition_failed"
+	CodeTooLarge             = "request_too_large"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeUnprocessable        = "unprocessable_entity"
+	CodeTooManyRequests      = "too_many_requests"
+	CodeInternal             = "internal_error"
+	CodeDBUnavailable        = "db_unavailable"
+	CodeUnavailable          = "service_unavailable"
+)
+
+// codes maps statuses to generic codes
+var codes = map[int]string{
+	http.StatusBadRequest:            CodeBadRequest,
+	http.StatusUnauthorized:          CodeUnauthorized,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	http.StatusConflict:              CodeConflict,
+	http.StatusPreconditionFailed:    CodePreconditionFailed,
+	http.StatusRequestEntityTooLarge: CodeTooLarge,
+	http.StatusUnsupportedMediaType:  CodeUnsupportedMediaType,
+	http.StatusUnprocessableEntity:   CodeUnprocessable,
+	http.StatusTooManyRequests:       CodeTooManyRequests,
+	http.StatusInternalServerError:   CodeInternal,
+	http.StatusServiceUnavailable:    CodeUnavailable,
+}
+
+// Problem is an error of API. Cause is an internal error, it is logged but never shown to client.
+// Errors lists invalid fields of request
+type Problem struct {
+	Type     string           `json:"type"`
+	Title    string           `json:"title"`
+	Status   int              `json:"status"`
+	Code     string           `json:"code"`
+	Detail   string           `json:"detail,omitempty"`
+	Instance string           `json:"instance,omitempty"`
+	Errors   validator.Errors `json:"errors,omitempty"`
+	Cause    error            `json:"-"`
+}
+
+// New constructor
+func New(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Code:   code,
+		Detail: detail,
+	}
+}
+
+// Error implements error interface
+func (p *Problem) Error() string {
+	if p.Cause != nil {
+		return p.Detail + ": " + p.Cause.Error()
+	}
+	return p.Detail
+}
+
+// WithCause returns copy of problem caused by err
+func (p *Problem) WithCause(err error) *Problem {
+	copied := *p
+	copied.Cause = err
+	return &copied
+}
+
+// BadRequest creates problem of malformed request
+func BadRequest(detail string) *Problem {
+	return New(http.StatusBadRequest, CodeBadRequest, detail)
+}
+
+// Validation creates problem of request that is well-formed but has invalid values
+func Validation(detail string) *Problem {
+	return New(http.StatusBadRequest, CodeValidation, detail)
+}
+
+// Fields creates validation problem listing every invalid field of request
+func Fields(errs validator.Errors) *Problem {
+	p := Validation(errs.Error())
+	p.Errors = errs
+	return p
+}
+
+// Forbidden creates problem of access denial
+func Forbidden(code, detail string) *Problem {
+	return New(http.StatusForbidden, code, detail)
+}
+
+// Bind creates problem of request that can not be bound to input
+func Bind(err error) *Problem {
+	if he, ok := err.(*echo.HTTPError); ok {
+		return New(http.StatusBadRequest, CodeBadRequest, messageOf(he))
+	}
+	return BadRequest(err.Error())
+}
+
+// DB creates problem of failed database operation, details of err are not shown to client
+func DB(err error) *Problem {
+	p := New(http.StatusServiceUnavailable, CodeDBUnavailable, "database is unavailable")
+	p.Cause = err
+	return p
+}
+
+// Internal creates problem of unexpected failure, details of err are not shown to client
+func Internal(err error) *Problem {
+	p := New(http.StatusInternalServerError, CodeInternal, "internal error")
+	p.Cause = err
+	return p
+}
+
+// TooManyRequests creates problem of throttled request and tells client when to retry
+func TooManyRequests(c echo.Context, code, detail string, retryAfter int) *Problem {
+	c.Response().Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	return New(http.StatusTooManyRequests, code, detail)
+}
+
+// ErrorHandler returns echo.HTTPErrorHandler that renders errors as problem details.
+// Errors other than Problem and echo.HTTPError are logged and hidden from client
+func ErrorHandler(log logger.Logger) echo.HTTPErrorHandler {
+	return func(err error, c echo.Context) {
+		var p Problem
+		switch e := err.(type) {
+		case *Problem:
+			p = *e
+		case validator.Errors:
+			p = *Fields(e)
+		case *echo.HTTPError:
+			p = *New(e.Code, codes[e.Code], messageOf(e))
+			if len(p.Code) == 0 {
+				p.Code = strconv.Itoa(e.Code)
+			}
+		default:
+			p = *Internal(err)
+		}
+		p.Instance = c.Request().URL.Path
+		if p.Cause != nil {
+			log.Error("problem", p.Code+" at "+c.Request().Method+" "+p.Instance+": "+p.Cause.Error())
+		}
+
+		if c.Response().Committed {
+			return
+		}
+		if c.Request().Method == http.MethodHead {
+			err = c.NoContent(p.Status)
+		} else {
+			data, _ := json.Marshal(p)
+			err = c.Blob(p.Status, MIMEApplicationProblemJSON, data)
+		}
+		if err != nil {
+			log.Error("problem", "error response failed: "+err.Error())
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+func messageOf(he *echo.HTTPError) string {
+	if s, ok := he.Message.(string); ok {
+		return s
+	}
+	return http.StatusText(he.Code)
+}
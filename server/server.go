@@ -1,27 +1,34 @@
 package server
 
 import (
+	"time"
+
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 
 	"github.com/nilvxingren/echoxormdemo/ctx"
+	"github.com/nilvxingren/echoxormdemo/keys"
 	"github.com/nilvxingren/echoxormdemo/logger"
+	"github.com/nilvxingren/echoxormdemo/server/access"
+	"github.com/nilvxingren/echoxormdemo/server/apikeys"
+	"github.com/nilvxingren/echoxormdemo/server/audit"
 	"github.com/nilvxingren/echoxormdemo/server/auth"
+	"github.com/nilvxingren/echoxormdemo/server/groups"
+	"github.com/nilvxingren/echoxormdemo/server/problem"
 	"github.com/nilvxingren/echoxormdemo/server/version"
 	"github.com/nilvxingren/echoxormdemo/server/users"
+	"github.com/nilvxingren/echoxormdemo/validator"
 )
 
 // Server is an main application object that shared (read-only) to application modules
 type Server struct {
-	context    *ctx.Context
-	signingKey []byte
+	context *ctx.Context
 }
 
 // New constructor
 func New(c *ctx.Context) *Server {
 	s := new(Server)
 	s.context = c
-	s.signingKey = []byte(c.Config.Secret)
 	return s
 }
 
@@ -30,31 +37,80 @@ func (s *Server) Run() {
 	// Echo instance
 	e := echo.New()
 	//e.Logger.SetLevel(log.ERROR)
+	e.HTTPErrorHandler = problem.ErrorHandler(s.context.Logger)
+	e.Validator = validator.New()
 
 	// Global Middleware
 	e.Use(logger.HTTPLogger(s.context.Logger))
 	e.Use(middleware.Recover())
 
 	var (
-		authHandler    = auth.Handler{C: s.context, Key: s.signingKey}
+		cfg         = s.context.Config
+		authHandler = auth.Handler{
+			C:            s.context,
+			Lockout:      auth.NewLockout(cfg.Auth.MaxFailedLogins, cfg.Auth.LockoutTime.Duration),
+			IPLimiter:    auth.NewRateLimiter(cfg.RateLimit.IPPerMinute, cfg.RateLimit.IPBurst),
+			LoginLimiter: auth.NewRateLimiter(cfg.RateLimit.LoginPerMinute, cfg.RateLimit.LoginBurst),
+		}
 		versionHandler = version.Handler{C: s.context}
-		usersHandler   = users.Handler{C: s.context}
+		usersHandler   = users.Handler{C: s.context, Sessions: &authHandler}
+		apiKeysHandler = apikeys.Handler{C: s.context}
+		groupsHandler  = groups.Handler{C: s.context}
+		auditHandler   = audit.Handler{C: s.context}
 	)
 
 	// Non-authored routes
-	e.POST("/auth", authHandler.PostAuth)
+	e.POST("/auth", authHandler.PostAuth, authHandler.RateLimitIP)
+	e.POST("/auth/refresh", authHandler.PostRefresh, authHandler.RateLimitIP)
+	e.POST("/auth/totp", authHandler.PostAuthTOTP, authHandler.RateLimitIP)
+	e.POST("/auth/password-reset", authHandler.PostPasswordReset, authHandler.RateLimitIP)
+	e.POST("/auth/password-reset/confirm", authHandler.PostPasswordResetConfirm, authHandler.RateLimitIP)
 	e.GET("/", versionHandler.GetVersion)
 	e.GET("/version", versionHandler.GetVersion)
+	e.GET("/.well-known/jwks.json", authHandler.GetJWKS)
 	// restricted
 	r := e.Group("")
 	// group middleware
-	r.Use(middleware.JWT(s.signingKey))
+	r.Use(apiKeysHandler.KeyOrJWT(keys.JWT(s.context.Keys)))
+	r.Use(authHandler.RejectRevoked)
+	r.Use(access.RestrictScope)
+	// auth
+	r.POST("/auth/logout", authHandler.PostLogout, access.TokenOnly)
+	r.DELETE("/users/:id/sessions", authHandler.DeleteSessions, access.AdminOnly)
 	// users
-	r.POST("/users", usersHandler.CreateUser)
-	r.GET("/users", usersHandler.GetAllUsers)
-	r.GET("/users/:id", usersHandler.GetUser)
-	r.PUT("/users/:id", usersHandler.PutUser)
-	r.DELETE("/users/:id", usersHandler.DeleteUser)
+	r.POST("/users", usersHandler.CreateUser, access.AdminOnly)
+	r.GET("/users", usersHandler.GetAllUsers, access.AdminOnly)
+	r.POST("/users/import", usersHandler.ImportUsers, access.AdminOnly)
+	r.GET("/users/export", usersHandler.ExportUsers, access.AdminOnly)
+	r.GET("/users/me", usersHandler.GetMe, access.TokenOnly)
+	r.PUT("/users/me", usersHandler.PutMe, access.TokenOnly)
+	r.DELETE("/users/me", authHandler.DeleteMe, access.TokenOnly)
+	r.POST("/users/me/password", usersHandler.ChangeMyPassword, access.TokenOnly)
+	r.POST("/users/me/totp", authHandler.PostTOTPEnroll, access.TokenOnly)
+	r.POST("/users/me/totp/confirm", authHandler.PostTOTPConfirm, access.TokenOnly)
+	r.GET("/users/me/api-keys", apiKeysHandler.GetAPIKeys, access.TokenOnly)
+	r.POST("/users/me/api-keys", apiKeysHandler.CreateAPIKey, access.TokenOnly)
+	r.DELETE("/users/me/api-keys/:id", apiKeysHandler.DeleteAPIKey, access.TokenOnly)
+	r.GET("/users/:id", usersHandler.GetUser, access.SelfOrAdmin("id"))
+	r.PUT("/users/:id", usersHandler.PutUser, access.SelfOrAdmin("id"))
+	r.PATCH("/users/:id", usersHandler.PatchUser, access.SelfOrAdmin("id"))
+	r.DELETE("/users/:id", usersHandler.DeleteUser, access.AdminOnly)
+	r.POST("/users/:id/restore", usersHandler.RestoreUser, access.AdminOnly)
+	// groups
+	r.GET("/groups", groupsHandler.GetAllGroups)
+	r.POST("/groups", groupsHandler.CreateGroup, access.AdminOnly)
+	r.GET("/groups/:id", groupsHandler.GetGroup)
+	r.PUT("/groups/:id", groupsHandler.PutGroup, access.AdminOnly)
+	r.DELETE("/groups/:id", groupsHandler.DeleteGroup, access.AdminOnly)
+	r.GET("/groups/:id/members", groupsHandler.GetMembers, access.AdminOnly)
+	r.POST("/groups/:id/members", groupsHandler.AddMember, access.AdminOnly)
+	r.DELETE("/groups/:id/members/:user_id", groupsHandler.RemoveMember, access.AdminOnly)
+	// audit
+	r.GET("/audit", auditHandler.GetAudit, access.AdminOnly)
+
+	// background jobs
+	go authHandler.CleanupRevoked(10 * time.Minute)
+	go usersHandler.PurgeDeleted(cfg.Users.Retention.Duration, cfg.Users.PurgeInterval.Duration)
 
 	// start server
 	e.Server.Addr = ":" + s.context.Config.Port
@@ -0,0 +1,364 @@
+package users
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/labstack/echo"
+
+	"github.com/nilvxingren/echoxormdemo/server/access"
+	"github.com/nilvxingren/echoxormdemo/server/audit"
+	"github.com/nilvxingren/echoxormdemo/server/problem"
+	"github.com/nilvxingren/echoxormdemo/validator"
+)
+
+// Formats of import and export
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+
+	MIMETextCSV           = "text/csv"
+	MIMEApplicationNDJSON = "application/x-ndjson"
+)
+
+// importBatchSize is a number of rows checked and inserted at once,
+// rows of request are limited by users.import_max_rows
+const importBatchSize = 100
+
+// errTooManyRows is returned by readers of import beyond limit of rows
+var errTooManyRows = errors.New("too many rows")
+
+// csvColumns are columns of export, import takes login, email, password and role
+var csvColumns = []string{"id", "login", "email", "role", "totp_enabled", "password_etime", "version", "created", "updated"}
+
+// ImportRow represents result of import of one row
+type ImportRow struct {
+	Row    int      `json:"row"` // 1-based, CSV header is not counted
+	Login  string   `json:"login"`
+	ID     uint64   `json:"id,omitempty"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// ImportResult represents response on import request
+type ImportResult struct {
+	DryRun  bool        `json:"dry_run"`
+	Total   int         `json:"total"`
+	Created int         `json:"created"`
+	Failed  int         `json:"failed"`
+	Rows    []ImportRow `json:"rows"`
+}
+
+// ImportUsers is a POST /users/import handler. Body is CSV with header or
+// JSON Lines of users input, format is taken from format parameter or
+// Content-Type. Rows are limited by users.import_max_rows and passwords are
+// hashed with users.import_bcrypt_cost. Valid rows are inserted in batched
+// transactions unless dry_run parameter is set; failed batch is rolled back as a whole
+func (h *Handler) ImportUsers(c echo.Context) error {
+	var (
+		inputs []CreateInput
+		result ImportResult
+		err    error
+	)
+
+	format := c.QueryParam("format")
+	if len(format) == 0 {
+		format = formatOf(c.Request().Header.Get(echo.HeaderContentType))
+	}
+	result.DryRun, _ = strconv.ParseBool(c.QueryParam("dry_run"))
+
+	maxRows := h.C.Config.Users.ImportMaxRows
+	switch format {
+	case FormatCSV:
+		inputs, err = readCSV(c.Request().Body, maxRows)
+	case FormatNDJSON:
+		inputs, err = readNDJSON(c.Request().Body, maxRows)
+	default:
+		return problem.New(http.StatusUnsupportedMediaType, problem.CodeUnsupportedMediaType, "import format not recognized")
+	}
+	if err == errTooManyRows {
+		return problem.New(http.StatusRequestEntityTooLarge, problem.CodeTooLarge, "import is limited to "+strconv.Itoa(maxRows)+" rows")
+	}
+	if err != nil {
+		return problem.BadRequest(err.Error())
+	}
+
+	// one query per batch finds taken logins
+	taken := make(map[string]bool)
+	for start := 0; start < len(inputs); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(inputs) {
+			end = len(inputs)
+		}
+		logins := make([]string, 0, end-start)
+		for _, input := range inputs[start:end] {
+			if len(input.Login) != 0 {
+				logins = append(logins, input.Login)
+			}
+		}
+		if len(logins) == 0 {
+			continue
+		}
+		batch, err := TakenLogins(h.C.Orm, logins)
+		if err != nil {
+			return err
+		}
+		for login := range batch {
+			taken[login] = true
+		}
+	}
+
+	// validate
+	etime := PasswordEtime(h.C.Config.Auth.PasswordLifetime.Duration)
+	result.Total = len(inputs)
+	result.Rows = make([]ImportRow, len(inputs))
+	valid := make([]*User, 0, len(inputs))
+	validRows := make([]*ImportRow, 0, len(inputs))
+	seen := make(map[string]bool, len(inputs))
+	for i, input := range inputs {
+		row := &result.Rows[i]
+		row.Row = i + 1
+		row.Login = input.Login
+		switch errs := c.Validate(&input).(type) {
+		case nil:
+		case validator.Errors:
+			for _, e := range errs {
+				row.Errors = append(row.Errors, e.Error())
+			}
+		default:
+			return errs
+		}
+		if len(input.Login) != 0 {
+			if seen[input.Login] {
+				row.Errors = append(row.Errors, "login is repeated in import")
+			}
+			seen[input.Login] = true
+			if taken[input.Login] {
+				row.Errors = append(row.Errors, ErrLoginTaken.Detail)
+			}
+		}
+		if len(row.Errors) != 0 {
+			continue
+		}
+		role := input.Role
+		if len(role) == 0 {
+			role = access.RoleUser
+		}
+		valid = append(valid, &User{Login: input.Login, Email: input.Email, Password: input.Password, Role: role, PasswordEtime: etime})
+		validRows = append(validRows, row)
+	}
+
+	if !result.DryRun {
+		if err = hashPasswords(valid, h.C.Config.Users.ImportBcryptCost); err != nil {
+			return problem.Internal(err)
+		}
+		for start := 0; start < len(valid); start += importBatchSize {
+			end := start + importBatchSize
+			if end > len(valid) {
+				end = len(valid)
+			}
+			failed, err := InsertBatch(h.C.Orm, valid[start:end])
+			if err != nil && failed < 0 {
+				return err
+			}
+			for i := start; i < end; i++ {
+				switch {
+				case err == nil:
+					validRows[i].ID = valid[i].ID
+					audit.Record(h.C, audit.New(c, audit.ActionUserCreate, audit.UserTarget(valid[i].ID)).WithChanges(audit.Diff(&User{}, valid[i])))
+				case i == start+failed:
+					validRows[i].Errors = append(validRows[i].Errors, ErrNotSaved.Detail)
+				default:
+					validRows[i].Errors = append(validRows[i].Errors, "batch rolled back")
+				}
+			}
+		}
+	}
+
+	for _, row := range result.Rows {
+		if len(row.Errors) != 0 {
+			result.Failed++
+		} else if !result.DryRun {
+			result.Created++
+		}
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// ExportUsers is a GET /users/export handler. It streams all users as CSV or
+// JSON Lines (format parameter, JSON Lines by default) without password hashes
+func (h *Handler) ExportUsers(c echo.Context) error {
+	var (
+		write func(u *User) error
+		flush func() error
+	)
+
+	format := c.QueryParam("format")
+	if len(format) == 0 {
+		format = FormatNDJSON
+	}
+	res := c.Response()
+	switch format {
+	case FormatCSV:
+		w := csv.NewWriter(res)
+		if err := w.Write(csvColumns); err != nil {
+			return err
+		}
+		write = func(u *User) error {
+			return w.Write([]string{
+				strconv.FormatUint(u.ID, 10), u.Login, u.Email, u.Role, strconv.FormatBool(u.TOTPEnabled),
+				strconv.FormatUint(u.PasswordEtime, 10), strconv.FormatUint(u.Version, 10),
+				strconv.FormatUint(u.Created, 10), strconv.FormatUint(u.Updated, 10),
+			})
+		}
+		flush = func() error {
+			w.Flush()
+			return w.Error()
+		}
+		res.Header().Set(echo.HeaderContentType, MIMETextCSV)
+	case FormatNDJSON:
+		encoder := json.NewEncoder(res)
+		write = func(u *User) error {
+			return encoder.Encode(u)
+		}
+		flush = func() error { return nil }
+		res.Header().Set(echo.HeaderContentType, MIMEApplicationNDJSON)
+	default:
+		return problem.Validation("export format not recognized")
+	}
+	res.Header().Set(echo.HeaderContentDisposition, `attachment; filename="users.`+format+`"`)
+	res.WriteHeader(http.StatusOK)
+
+	// headers are sent already, so errors can only be logged
+	err := h.C.Orm.Omit("password", "totp_secret", "totp_recovery").Asc("id").Iterate(new(User), func(i int, bean interface{}) error {
+		if err := write(bean.(*User)); err != nil {
+			return err
+		}
+		if (i+1)%importBatchSize == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			res.Flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		h.C.Logger.Error("users", "export error: "+err.Error())
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
+// formatOf returns import format of media type
+func formatOf(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case MIMETextCSV:
+		return FormatCSV
+	case MIMEApplicationNDJSON, "application/jsonl", "application/json-lines":
+		return FormatNDJSON
+	}
+	return ""
+}
+
+// readCSV reads users input from CSV with header, unknown columns are ignored
+func readCSV(r io.Reader, maxRows int) ([]CreateInput, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("CSV header not recognized: " + err.Error())
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[name] = i
+	}
+	if _, ok := columns["login"]; !ok {
+		return nil, errors.New("CSV header has no login column")
+	}
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return record[i]
+	}
+
+	var inputs []CreateInput
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return inputs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(inputs) == maxRows {
+			return nil, errTooManyRows
+		}
+		inputs = append(inputs, CreateInput{
+			Login:    field(record, "login"),
+			Email:    field(record, "email"),
+			Password: field(record, "password"),
+			Role:     field(record, "role"),
+		})
+	}
+}
+
+// readNDJSON reads users input from JSON Lines
+func readNDJSON(r io.Reader, maxRows int) ([]CreateInput, error) {
+	var inputs []CreateInput
+	decoder := json.NewDecoder(r)
+	for {
+		var input CreateInput
+		err := decoder.Decode(&input)
+		if err == io.EOF {
+			return inputs, nil
+		}
+		if err != nil {
+			return nil, errors.New("line " + strconv.Itoa(len(inputs)+1) + ": " + err.Error())
+		}
+		if len(inputs) == maxRows {
+			return nil, errTooManyRows
+		}
+		inputs = append(inputs, input)
+	}
+}
+
+// hashPasswords replaces passwords of users with their hashes of given cost using all CPUs
+func hashPasswords(batch []*User, cost int) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		fail error
+	)
+	jobs := make(chan *User)
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range jobs {
+				if err := u.HashPasswordCost(u.Password, u.PasswordEtime, cost); err != nil {
+					mu.Lock()
+					fail = err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, u := range batch {
+		jobs <- u
+	}
+	close(jobs)
+	wg.Wait()
+	return fail
+}
@@ -4,136 +4,425 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/go-xorm/xorm"
 	"github.com/labstack/echo"
 
 	"github.com/nilvxingren/echoxormdemo/ctx"
+	"github.com/nilvxingren/echoxormdemo/server/access"
+	"github.com/nilvxingren/echoxormdemo/server/audit"
+	"github.com/nilvxingren/echoxormdemo/server/groups"
+	"github.com/nilvxingren/echoxormdemo/server/problem"
 )
 
-// Input represents payload data format
+// Errors of users handlers
+var (
+	ErrPreconditionFailed = problem.New(http.StatusPreconditionFailed, problem.CodePreconditionFailed, "user does not match If-Match")
+	ErrRoleChange         = problem.Forbidden("role_change_forbidden", "role can be changed by admin only")
+	ErrPasswordChange     = problem.Forbidden("password_change_forbidden", "password can be set by admin only, change own password by POST /users/me/password")
+	ErrPasswordMismatch   = problem.Forbidden("password_mismatch", "current password mismatch")
+)
+
+// Input represents payload data format, password may be omitted to keep current one
 type Input struct {
-	Login    string `json:"login"`
-	Password string `json:"password"`
+	Login    string `json:"login" validate:"required,min=3,max=32,login"`
+	Email    string `json:"email,omitempty" validate:"omitempty,max=254,email"`
+	Password string `json:"password" validate:"omitempty,min=8,max=72,password"`
+	Role     string `json:"role,omitempty" validate:"omitempty,oneof=admin user"`
+}
+
+// CreateInput represents payload data format of new user, password is required
+type CreateInput struct {
+	Login    string `json:"login" validate:"required,min=3,max=32,login"`
+	Email    string `json:"email,omitempty" validate:"omitempty,max=254,email"`
+	Password string `json:"password" validate:"required,min=8,max=72,password"`
+	Role     string `json:"role,omitempty" validate:"omitempty,oneof=admin user"`
+}
+
+// PasswordInput represents payload data format of password change
+type PasswordInput struct {
+	CurrentPassword string `json:"current_password" validate:"required,max=72"`
+	NewPassword     string `json:"new_password" validate:"required,min=8,max=72,password"`
+}
+
+// ProfileInput represents payload data format of own profile, role and
+// password are not changed by it
+type ProfileInput struct {
+	Login string `json:"login" validate:"required,min=3,max=32,login"`
+	Email string `json:"email,omitempty" validate:"omitempty,max=254,email"`
 }
 
 // Handler is a container for handlers and app data
 type Handler struct {
-	C *ctx.Context
+	C        *ctx.Context
+	Sessions Sessions
+}
+
+// Sessions revokes tokens of users. It is implemented by auth handler,
+// which depends on users package itself
+type Sessions interface {
+	// RevokeSessions revokes every access and refresh token and API key of user
+	RevokeSessions(userID uint64) error
+	// CheckLockout fails request of login locked out by failed password checks
+	CheckLockout(c echo.Context, login string) error
+	// PasswordFailed counts failed password check of login towards lockout
+	PasswordFailed(login string)
+	// PurgeSessions deletes tokens and API keys of users purged in session s
+	PurgeSessions(s *xorm.Session, userIDs []uint64) error
+}
+
+// ListResult represents response on users list request
+type ListResult struct {
+	Total      int64             `json:"total"`
+	Limit      int               `json:"limit"`
+	Offset     int               `json:"offset"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+	Links      map[string]string `json:"links"`
+	Items      []User            `json:"items"`
 }
 
 // GetAllUsers is a GET /users handler
 func (h *Handler) GetAllUsers(c echo.Context) error {
-	users, err := new(User).FindAll(h.C.Orm)
+	query, err := ParseQuery(c.QueryParams())
 	if err != nil {
-		return c.String(http.StatusServiceUnavailable, err.Error())
+		return err
 	}
-	return c.JSON(http.StatusOK, users)
+	users, total, next, err := query.Find(h.C.Orm)
+	if err != nil {
+		return err
+	}
+
+	result := ListResult{
+		Total:      total,
+		Limit:      query.Limit,
+		Offset:     query.Offset,
+		NextCursor: next,
+		Links:      map[string]string{"self": c.Request().URL.RequestURI()},
+		Items:      users,
+	}
+	if len(next) != 0 {
+		u := *c.Request().URL
+		params := u.Query()
+		params.Del("offset")
+		params.Set("cursor", next)
+		u.RawQuery = params.Encode()
+		result.Links["next"] = u.RequestURI()
+	}
+	return c.JSON(http.StatusOK, result)
 }
 
-// GetUser is a GET /users/{id} handler
+// GetUser is a GET /users/{id} handler, it supports If-None-Match and include=groups
 func (h *Handler) GetUser(c echo.Context) error {
 	var (
-		user   User
-		err    error
-		status int
+		user User
+		err  error
 	)
 
 	user.ID, err = strconv.ParseUint(c.Param("id"), 10, 0)
 	if err != nil {
-		return c.String(http.StatusBadRequest, err.Error())
+		return problem.BadRequest("id not recognized")
 	}
 
-	status, err = user.Find(h.C.Orm)
+	err = user.Find(h.C.Orm)
 	if err != nil {
-		return c.String(status, err.Error())
+		return err
 	}
-	return c.JSON(http.StatusOK, user)
+	return h.show(c, &user)
+}
+
+// GetMe is a GET /users/me handler, it resolves user by token claims and
+// supports the same conditions and includes as GetUser
+func (h *Handler) GetMe(c echo.Context) error {
+	var user User
+
+	user.ID = access.UserID(c)
+	if user.ID == 0 {
+		return echo.ErrUnauthorized
+	}
+	if err := user.Find(h.C.Orm); err != nil {
+		return err
+	}
+	return h.show(c, &user)
 }
 
 // CreateUser is a POST /users handler
 func (h *Handler) CreateUser(c echo.Context) error {
 	var (
-		status int
-		err    error
-		user   User
-		input  Input
+		err   error
+		user  User
+		input CreateInput
 	)
 
 	if err = c.Bind(&input); err != nil {
-		return c.String(http.StatusBadRequest, err.Error())
-	}
-
-	// validate
-	if len(input.Login) == 0 {
-		return c.String(http.StatusBadRequest, "login not recognized")
+		return problem.Bind(err)
 	}
-	if len(input.Password) == 0 {
-		return c.String(http.StatusBadRequest, "password not recognized")
+	if err = c.Validate(&input); err != nil {
+		return err
 	}
 
 	// create
 	user = User{
-		Login:    input.Login,
-		Password: input.Password,
+		Login:         input.Login,
+		Email:         input.Email,
+		Password:      input.Password,
+		PasswordEtime: PasswordEtime(h.C.Config.Auth.PasswordLifetime.Duration),
+		Role:          input.Role,
 	}
 	// save
-	status, err = user.Save(h.C.Orm)
+	err = user.Save(h.C.Orm)
 	if err != nil {
-		return c.String(status, err.Error())
+		return err
 	}
+	audit.Record(h.C, audit.New(c, audit.ActionUserCreate, audit.UserTarget(user.ID)).WithChanges(audit.Diff(&User{}, &user)))
 	return c.JSON(http.StatusCreated, user)
 }
 
-// PutUser is a PUT /users/{id} handler
+// PutUser is a PUT /users/{id} handler. It replaces user as a whole: omitted
+// email is cleared and omitted role becomes default one. Password is write-only,
+// it is kept if omitted and may be set by admin only. It supports If-Match
 func (h *Handler) PutUser(c echo.Context) error {
 	var (
-		input  Input
-		user   User
-		id     uint64
-		err    error
-		status int
+		input Input
+		user  User
+		err   error
 	)
 	// parse id
-	id, err = strconv.ParseUint(c.Param("id"), 10, 0)
+	user.ID, err = strconv.ParseUint(c.Param("id"), 10, 0)
 	if err != nil {
-		return c.String(http.StatusBadRequest, err.Error())
+		return problem.BadRequest("id not recognized")
 	}
 	// parse request body
 	if err = c.Bind(&input); err != nil {
-		return c.String(http.StatusBadRequest, err.Error())
+		return problem.Bind(err)
 	}
-	// construct user
-	user = User{
-		ID:       id,
-		Login:    input.Login,
-		Password: input.Password,
+	if len(input.Role) == 0 {
+		input.Role = access.RoleUser
 	}
-	// update
-	status, err = user.Update(h.C.Orm)
+	err = user.Find(h.C.Orm)
 	if err != nil {
-		return c.String(status, err.Error())
+		return err
 	}
-	return c.JSON(http.StatusOK, user)
+	if !ifMatch(c, &user) {
+		return ErrPreconditionFailed
+	}
+	return h.replace(c, &user, input)
+}
+
+// PutMe is a PUT /users/me handler. It replaces login and email of user of
+// token, omitted email is cleared. It supports If-Match
+func (h *Handler) PutMe(c echo.Context) error {
+	var (
+		input ProfileInput
+		user  User
+		err   error
+	)
+
+	if err = c.Bind(&input); err != nil {
+		return problem.Bind(err)
+	}
+	if err = c.Validate(&input); err != nil {
+		return err
+	}
+	user.ID = access.UserID(c)
+	if user.ID == 0 {
+		return echo.ErrUnauthorized
+	}
+	err = user.Find(h.C.Orm)
+	if err != nil {
+		return err
+	}
+	if !ifMatch(c, &user) {
+		return ErrPreconditionFailed
+	}
+	return h.replace(c, &user, Input{Login: input.Login, Email: input.Email, Role: user.Role})
 }
 
-// DeleteUser is a DELETE /users/{id} handler
+// DeleteUser is a DELETE /users/{id} handler. It revokes sessions of user
+// and supports If-Match
 func (h *Handler) DeleteUser(c echo.Context) error {
 	var (
-		id     uint64
-		status int
-		err    error
-		user   User
+		id   uint64
+		err  error
+		user User
 	)
 
 	id, err = strconv.ParseUint(c.Param("id"), 10, 0)
 	if err != nil {
-		return c.String(http.StatusBadRequest, err.Error())
+		return problem.BadRequest("id not recognized")
 	}
 
 	user.ID = id
+	// conditional request deletes the matching version of user only
+	if len(c.Request().Header.Get(headerIfMatch)) != 0 {
+		err = user.Find(h.C.Orm)
+		if err != nil {
+			return err
+		}
+		if !ifMatch(c, &user) {
+			return ErrPreconditionFailed
+		}
+	}
 	// delete
-	status, err = user.Delete(h.C.Orm)
+	err = user.Delete(h.C.Orm)
 	if err != nil {
-		return c.String(status, err.Error())
+		return modifiedProblem(c, err)
+	}
+	audit.Record(h.C, audit.New(c, audit.ActionUserDelete, audit.UserTarget(user.ID)))
+	// restored user logs in again
+	if err = h.Sessions.RevokeSessions(user.ID); err != nil {
+		return err
 	}
 	return c.NoContent(http.StatusOK)
 }
+
+// RestoreUser is a POST /users/{id}/restore handler
+func (h *Handler) RestoreUser(c echo.Context) error {
+	var (
+		user User
+		err  error
+	)
+
+	user.ID, err = strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		return problem.BadRequest("id not recognized")
+	}
+	err = user.Restore(h.C.Orm)
+	if err != nil {
+		return err
+	}
+	audit.Record(h.C, audit.New(c, audit.ActionUserRestore, audit.UserTarget(user.ID)))
+	c.Response().Header().Set(headerETag, user.ETag())
+	return c.JSON(http.StatusOK, user)
+}
+
+// ChangeMyPassword is a POST /users/me/password handler.
+// Allowed with token of expired password too. Wrong current password counts
+// towards lockout of login, every session of user is revoked on success
+func (h *Handler) ChangeMyPassword(c echo.Context) error {
+	var (
+		input PasswordInput
+		user  User
+		err   error
+	)
+
+	if err = c.Bind(&input); err != nil {
+		return problem.Bind(err)
+	}
+	if err = c.Validate(&input); err != nil {
+		return err
+	}
+
+	user.ID = access.UserID(c)
+	if user.ID == 0 {
+		return echo.ErrUnauthorized
+	}
+	err = user.Find(h.C.Orm)
+	if err != nil {
+		return err
+	}
+	if err = h.Sessions.CheckLockout(c, user.Login); err != nil {
+		return err
+	}
+	if !user.CheckPassword(input.CurrentPassword) {
+		h.Sessions.PasswordFailed(user.Login)
+		return ErrPasswordMismatch
+	}
+	if input.NewPassword == input.CurrentPassword {
+		return problem.Validation("new password must differ from current one")
+	}
+
+	before := user
+	err = user.SetPassword(h.C.Orm, input.NewPassword, PasswordEtime(h.C.Config.Auth.PasswordLifetime.Duration))
+	if err != nil {
+		return err
+	}
+	audit.Record(h.C, audit.New(c, audit.ActionUserUpdate, audit.UserTarget(user.ID)).WithChanges(audit.Diff(&before, &user)))
+	// tokens and keys got with old password are of no use anymore, new ones are got by login
+	if err = h.Sessions.RevokeSessions(user.ID); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, user)
+}
+
+//------------------------------------------------------------------------------
+// replace validates new fields of found user and writes changed ones to database
+func (h *Handler) replace(c echo.Context, user *User, input Input) error {
+	var (
+		cols []string
+		err  error
+	)
+
+	// only admin may change roles
+	if input.Role != user.Role && !access.IsAdmin(c) {
+		return ErrRoleChange
+	}
+	// own password is changed with current one only, see ChangeMyPassword
+	if len(input.Password) != 0 && !access.IsAdmin(c) {
+		return ErrPasswordChange
+	}
+	if err = c.Validate(&input); err != nil {
+		return err
+	}
+
+	before := *user
+	if input.Login != user.Login {
+		user.Login = input.Login
+		err = user.CheckLogin(h.C.Orm)
+		if err != nil {
+			return err
+		}
+		cols = append(cols, "login")
+	}
+	if input.Email != user.Email {
+		user.Email = input.Email
+		cols = append(cols, "email")
+	}
+	if input.Role != user.Role {
+		user.Role = input.Role
+		cols = append(cols, "role")
+	}
+	if len(input.Password) != 0 {
+		// new password gets new expiration time, even if it is "never"
+		err = user.HashPassword(input.Password, PasswordEtime(h.C.Config.Auth.PasswordLifetime.Duration))
+		if err != nil {
+			return problem.Internal(err)
+		}
+		cols = append(cols, "password", "password_etime")
+	}
+	if len(cols) != 0 {
+		err = user.UpdateCols(h.C.Orm, cols...)
+		if err != nil {
+			return modifiedProblem(c, err)
+		}
+		audit.Record(h.C, audit.New(c, audit.ActionUserUpdate, audit.UserTarget(user.ID)).WithChanges(audit.Diff(&before, user)))
+	}
+	// whoever had the old password must not stay logged in, tokens carry old role
+	if len(input.Password) != 0 || user.Role != before.Role {
+		if err = h.Sessions.RevokeSessions(user.ID); err != nil {
+			return err
+		}
+	}
+	c.Response().Header().Set(headerETag, user.ETag())
+	return c.JSON(http.StatusOK, user)
+}
+
+// show responds with found user, it supports If-None-Match and include=groups
+func (h *Handler) show(c echo.Context, user *User) error {
+	var err error
+
+	// memberships are not versioned with user, so representation with them has no ETag
+	if include := c.QueryParam("include"); len(include) != 0 {
+		if include != "groups" {
+			return problem.Validation("include not recognized: " + include)
+		}
+		user.Groups, err = groups.OfUser(h.C.Orm, user.ID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, user)
+	}
+	c.Response().Header().Set(headerETag, user.ETag())
+	if !ifNoneMatch(c, user) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.JSON(http.StatusOK, user)
+}
@@ -0,0 +1,62 @@
+package users
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// Headers of conditional requests
+const (
+	headerETag        = "ETag"
+	headerIfMatch     = "If-Match"
+	headerIfNoneMatch = "If-None-Match"
+)
+
+// ETag returns strong entity tag of user, it changes with every update of user
+func (u *User) ETag() string {
+	return `"` + strconv.FormatUint(u.ID, 10) + "-" + strconv.FormatUint(u.Version, 10) + `"`
+}
+
+//------------------------------------------------------------------------------
+// matchETag checks if value of If-Match or If-None-Match header lists etag.
+// Weak tags are matched only if weak comparison is allowed
+func matchETag(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = tag[2:]
+		}
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatch checks that request has no If-Match header or it matches found user
+func ifMatch(c echo.Context, u *User) bool {
+	header := c.Request().Header.Get(headerIfMatch)
+	return len(header) == 0 || matchETag(header, u.ETag(), false)
+}
+
+// ifNoneMatch checks that request has no If-None-Match header or it does not match found user
+func ifNoneMatch(c echo.Context, u *User) bool {
+	header := c.Request().Header.Get(headerIfNoneMatch)
+	return len(header) == 0 || !matchETag(header, u.ETag(), true)
+}
+
+// modifiedProblem turns error of concurrent modification into 412 for conditional requests
+func modifiedProblem(c echo.Context, err error) error {
+	if err == ErrModified && len(c.Request().Header.Get(headerIfMatch)) != 0 {
+		return ErrPreconditionFailed
+	}
+	return err
+}
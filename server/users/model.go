@@ -1,26 +1,43 @@
 package users
 
 import (
-	"errors"
 	"net/http"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
 	"github.com/go-xorm/xorm"
+
+	"github.com/nilvxingren/echoxormdemo/server/access"
+	"github.com/nilvxingren/echoxormdemo/server/groups"
+	"github.com/nilvxingren/echoxormdemo/server/problem"
+)
+
+// Errors of users model
+var (
+	ErrNotFound   = problem.New(http.StatusNotFound, "user_not_found", "user not found")
+	ErrLoginTaken = problem.New(http.StatusConflict, "login_taken", "such user always exists")
+	ErrModified   = problem.New(http.StatusConflict, "user_modified", "user was modified concurrently")
+	ErrNotDeleted = problem.New(http.StatusConflict, "user_not_deleted", "user is not deleted")
+	ErrNotSaved   = problem.New(http.StatusUnprocessableEntity, "user_not_saved", "db refused to save user")
 )
 
 // User is an entity (here are DB definitions)
 type User struct {
-	ID            uint64 `xorm:"'id' pk autoincr unique notnull" json:"id"`
-	Login         string `xorm:"text index not null unique 'login'" json:"login"`
-	Email         string `xorm:"text 'email'" json:"email"`
-	Password      string `xorm:"text not null 'password'" json:"-"`
-	PasswordEtime uint64 `json:"password_etime"`
-	Created       uint64 `xorm:"created" json:"created"`
-	Updated       uint64 `xorm:"updated" json:"updated"`
-	//Group         ctx.Group `json:"group" xorm:"-"`
-	//GroupID uint64 `json:"-" xorm:"'group_id' index"`
-
+	ID            uint64         `xorm:"'id' pk autoincr unique notnull" json:"id"`
+	Login         string         `xorm:"text index not null unique 'login'" json:"login"`
+	Email         string         `xorm:"text 'email'" json:"email"`
+	Password      string         `xorm:"text not null 'password'" json:"-" audit:"redact"`
+	Role          string         `xorm:"text not null default 'user' 'role'" json:"role"`
+	TOTPSecret    string         `xorm:"text 'totp_secret'" json:"-"` // encrypted
+	TOTPEnabled   bool           `xorm:"'totp_enabled'" json:"totp_enabled"`
+	TOTPLastStep  int64          `xorm:"'totp_last_step'" json:"-"`
+	TOTPRecovery  string         `xorm:"text 'totp_recovery'" json:"-"` // space separated hashes of unused recovery codes
+	PasswordEtime uint64         `xorm:"'password_etime'" json:"password_etime"`
+	Version       uint64         `xorm:"'version' not null default 1" json:"version" audit:"-"` // incremented on every update
+	Created       uint64         `xorm:"created" json:"created" audit:"-"`
+	Updated       uint64         `xorm:"updated" json:"updated" audit:"-"`
+	Deleted       time.Time      `xorm:"deleted 'deleted'" json:"-"`          // soft delete, deleted users are hidden by xorm
+	Groups        []groups.Group `xorm:"-" json:"groups,omitempty" audit:"-"` // filled on request only
 }
 
 // TableName used by xorm to set table name for entity
@@ -30,94 +47,195 @@ func (u *User) TableName() string {
 
 // FindAll users in database
 func (u *User) FindAll(orm *xorm.Engine) ([]User, error) {
-	var (
-		users []User
-		err   error
-	)
-	err = orm.Find(&users)
-	return users, err
+	var users []User
+	if err := orm.Find(&users); err != nil {
+		return nil, problem.DB(err)
+	}
+	return users, nil
 }
 
 // Find user in database
-func (u *User) Find(orm *xorm.Engine) (int, error) {
+func (u *User) Find(orm *xorm.Engine) error {
 	found, err := orm.Get(u)
 	if err != nil {
-		return http.StatusServiceUnavailable, err
+		return problem.DB(err)
 	}
 	if !found {
-		return http.StatusNotFound, errors.New("user not found")
+		return ErrNotFound
 	}
-	return http.StatusOK, nil
+	return nil
+}
+
+// FindByEmail finds the only user having email. Email is not unique, so
+// ErrNotFound is returned if it is shared by several users too
+func (u *User) FindByEmail(orm *xorm.Engine, email string) error {
+	var found []User
+	err := orm.Where("email = ?", email).Limit(2).Find(&found)
+	if err != nil {
+		return problem.DB(err)
+	}
+	if len(found) != 1 {
+		return ErrNotFound
+	}
+	*u = found[0]
+	return nil
 }
 
 // Save user to database
-func (u *User) Save(orm *xorm.Engine) (int, error) {
+func (u *User) Save(orm *xorm.Engine) error {
 	var (
 		err      error
 		hash     []byte
 		affected int64
 	)
-	affected, err = orm.Where("login = ?", u.Login).Count(&User{})
+	// login of deleted user is taken until it is purged
+	affected, err = orm.Unscoped().Where("login = ?", u.Login).Count(&User{})
 	if err != nil {
-		return http.StatusServiceUnavailable, err
+		return problem.DB(err)
 	}
 	if affected != 0 {
-		return http.StatusConflict, errors.New("such user always exists")
+		return ErrLoginTaken
+	}
+
+	if len(u.Role) == 0 {
+		u.Role = access.RoleUser
 	}
 
 	// encrypt password
 	hash, err = bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
 	if err != nil {
-		return http.StatusServiceUnavailable, err
+		return problem.Internal(err)
 	}
 	u.Password = string(hash[:])
 
 	u.Created = uint64(time.Now().UTC().Unix())
 	u.Updated = u.Created
+	u.Version = 1
 	affected, err = orm.InsertOne(u)
 	if err != nil {
-		return http.StatusServiceUnavailable, err
+		return problem.DB(err)
 	}
 	if affected == 0 {
-		return http.StatusUnprocessableEntity, errors.New("db refused to insert such user")
+		return ErrNotSaved
 	}
+	return nil
+}
 
-	return http.StatusCreated, nil
+// InsertBatch inserts new users in one transaction, passwords must be hashed already.
+// If some user is not inserted then none are and index of that user is returned, -1 otherwise
+func InsertBatch(orm *xorm.Engine, batch []*User) (int, error) {
+	session := orm.NewSession()
+	defer session.Close()
+	if err := session.Begin(); err != nil {
+		return -1, problem.DB(err)
+	}
+	now := uint64(time.Now().UTC().Unix())
+	for i, u := range batch {
+		u.Created = now
+		u.Updated = now
+		u.Version = 1
+		affected, err := session.InsertOne(u)
+		if err != nil || affected == 0 {
+			session.Rollback()
+			return i, ErrNotSaved.WithCause(err)
+		}
+	}
+	if err := session.Commit(); err != nil {
+		return -1, problem.DB(err)
+	}
+	return -1, nil
 }
 
-// Update user in database
-func (u *User) Update(orm *xorm.Engine) (int, error) {
-	var (
-		err      error
-		found    bool
-		user     User
-		affected int64
-	)
-	// get old user data (and check if user exists)
-	found, err = orm.ID(u.ID).Get(&user)
+// Restore user deleted softly
+func (u *User) Restore(orm *xorm.Engine) error {
+	found, err := orm.Unscoped().ID(u.ID).Get(u)
 	if err != nil {
-		return http.StatusServiceUnavailable, err
+		return problem.DB(err)
 	}
 	if !found {
-		return http.StatusNotFound, errors.New("user not found")
+		return ErrNotFound
+	}
+	if u.Deleted.IsZero() {
+		return ErrNotDeleted
 	}
-	err = u.setFieldsFrom(user)
+	fields := map[string]interface{}{
+		"deleted": nil,
+		"updated": uint64(time.Now().UTC().Unix()),
+		"version": u.Version + 1,
+	}
+	affected, err := orm.Unscoped().Table(u).ID(u.ID).And("version = ?", u.Version).Update(fields)
 	if err != nil {
-		return http.StatusServiceUnavailable, err
+		return problem.DB(err)
 	}
-	u.Updated = uint64(time.Now().UTC().Unix())
-	affected, err = orm.Update(u)
+	if affected == 0 {
+		return ErrModified
+	}
+	u.Deleted = time.Time{}
+	u.Updated = fields["updated"].(uint64)
+	u.Version++
+	return nil
+}
+
+// PurgeDeleted removes users deleted before given time from database for good.
+// Memberships of users and what sessions deletes of other packages are removed
+// in the same transaction
+func PurgeDeleted(orm *xorm.Engine, before time.Time, sessions func(s *xorm.Session, userIDs []uint64) error) (int64, error) {
+	var ids []uint64
+	err := orm.Unscoped().Table(&User{}).Cols("id").Where("deleted IS NOT NULL AND deleted < ?", before).Find(&ids)
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	session := orm.NewSession()
+	defer session.Close()
+	if err = session.Begin(); err != nil {
+		return 0, err
+	}
+	if err = sessions(session, ids); err != nil {
+		session.Rollback()
+		return 0, err
+	}
+	if _, err = session.In("user_id", ids).Delete(&groups.Membership{}); err != nil {
+		session.Rollback()
+		return 0, err
+	}
+	purged, err := session.Unscoped().In("id", ids).Delete(&User{})
 	if err != nil {
-		return http.StatusServiceUnavailable, err
+		session.Rollback()
+		return 0, err
 	}
-	if affected != 0 {
-		return http.StatusUnprocessableEntity, errors.New("db refused to update")
+	if err = session.Commit(); err != nil {
+		return 0, err
 	}
-	return http.StatusOK, nil
+	return purged, nil
 }
 
-// Delete user from database
-func (u *User) Delete(orm *xorm.Engine) (int, error) {
+// CheckLogin checks that login is not taken by another user
+func (u *User) CheckLogin(orm *xorm.Engine) error {
+	count, err := orm.Unscoped().Where("login = ? AND id <> ?", u.Login, u.ID).Count(&User{})
+	if err != nil {
+		return problem.DB(err)
+	}
+	if count != 0 {
+		return ErrLoginTaken
+	}
+	return nil
+}
+
+// TakenLogins returns which of logins are taken by users, deleted ones included
+func TakenLogins(orm *xorm.Engine, logins []string) (map[string]bool, error) {
+	var found []User
+	if err := orm.Unscoped().Cols("login").In("login", logins).Find(&found); err != nil {
+		return nil, problem.DB(err)
+	}
+	taken := make(map[string]bool, len(found))
+	for _, u := range found {
+		taken[u.Login] = true
+	}
+	return taken, nil
+}
+
+// Delete user softly, deleted user may be restored until it is purged
+func (u *User) Delete(orm *xorm.Engine) error {
 	var (
 		err      error
 		found    bool
@@ -127,35 +245,95 @@ func (u *User) Delete(orm *xorm.Engine) (int, error) {
 	// check if user exists
 	found, err = orm.ID(u.ID).Get(&user)
 	if err != nil {
-		return http.StatusServiceUnavailable, err
+		return problem.DB(err)
 	}
 	if !found {
-		return http.StatusNotFound, errors.New("user not exists")
+		return ErrNotFound
+	}
+	// delete, only given version of user if it is set
+	session := orm.ID(u.ID)
+	if u.Version != 0 {
+		session = session.And("version = ?", u.Version)
 	}
-	//delete
-	affected, err = orm.ID(u.ID).Delete(&User{})
+	affected, err = session.Delete(&User{})
 	if err != nil {
-		return http.StatusServiceUnavailable, err
+		return problem.DB(err)
 	}
 	if affected == 0 {
-		return http.StatusUnprocessableEntity, errors.New("db refused to delete user")
+		return u.missingOrModified(orm)
 	}
-	return http.StatusOK, nil
+	return nil
 }
 
-//------------------------------------------------------------------------------
-func (u *User) setFieldsFrom(user User) error {
-	if len(u.Login) == 0 {
-		u.Login = user.Login
-	}
-	if len(u.Password) == 0 {
-		u.Password = user.Password
-	} else {
-		hash, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
-		if err != nil {
-			return err
-		}
-		u.Password = string(hash[:8])
+// UpdateCols writes given columns of user to database as they are, zero values included.
+// Update succeeds only if user in database is of the same version as u
+func (u *User) UpdateCols(orm *xorm.Engine, cols ...string) error {
+	version := u.Version
+	u.Version++
+	u.Updated = uint64(time.Now().UTC().Unix())
+	affected, err := orm.ID(u.ID).And("version = ?", version).Cols(append(cols, "updated", "version")...).Update(u)
+	if err != nil {
+		u.Version = version
+		return problem.DB(err)
+	}
+	if affected == 0 {
+		u.Version = version
+		return u.missingOrModified(orm)
+	}
+	return nil
+}
+
+// SetPassword replaces user password in database, password expires at etime (never if 0)
+func (u *User) SetPassword(orm *xorm.Engine, password string, etime uint64) error {
+	if err := u.HashPassword(password, etime); err != nil {
+		return problem.Internal(err)
 	}
+	return u.UpdateCols(orm, "password", "password_etime")
+}
+
+// HashPassword sets user password hash and expiration time without saving them
+func (u *User) HashPassword(password string, etime uint64) error {
+	return u.HashPasswordCost(password, etime, bcrypt.DefaultCost)
+}
+
+// HashPasswordCost is HashPassword with given bcrypt cost
+func (u *User) HashPasswordCost(password string, etime uint64, cost int) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return err
+	}
+	u.Password = string(hash[:])
+	u.PasswordEtime = etime
 	return nil
 }
+
+// CheckPassword checks if password matches user password hash
+func (u *User) CheckPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) == nil
+}
+
+// IsPasswordExpired checks if user password has to be changed before use
+func (u *User) IsPasswordExpired() bool {
+	return u.PasswordEtime != 0 && uint64(time.Now().UTC().Unix()) >= u.PasswordEtime
+}
+
+// PasswordEtime returns expiration time of password set now, 0 (never expires) if lifetime is not positive
+func PasswordEtime(lifetime time.Duration) uint64 {
+	if lifetime <= 0 {
+		return 0
+	}
+	return uint64(time.Now().Add(lifetime).UTC().Unix())
+}
+
+//------------------------------------------------------------------------------
+// missingOrModified tells why user of given version was not found in database
+func (u *User) missingOrModified(orm *xorm.Engine) error {
+	count, err := orm.ID(u.ID).Count(&User{})
+	if err != nil {
+		return problem.DB(err)
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return ErrModified
+}
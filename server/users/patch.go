@@ -0,0 +1,113 @@
+package users
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo"
+
+	"github.com/nilvxingren/echoxormdemo/jsonpatch"
+	"github.com/nilvxingren/echoxormdemo/server/access"
+	"github.com/nilvxingren/echoxormdemo/server/problem"
+)
+
+// Errors of patch
+var (
+	ErrPatchMediaType  = problem.New(http.StatusUnsupportedMediaType, problem.CodeUnsupportedMediaType, "patch media type not recognized")
+	ErrPatchTestFailed = problem.New(http.StatusConflict, "patch_test_failed", "test operation of patch failed")
+)
+
+// Document represents user fields that may be patched. Password is write-only,
+// so it is absent in document until patch adds it
+type Document struct {
+	Login    *string `json:"login"`
+	Email    *string `json:"email"`
+	Role     *string `json:"role"`
+	Password *string `json:"password,omitempty"`
+}
+
+// PatchUser is a PATCH /users/{id} handler. Body is either JSON Merge Patch
+// (application/merge-patch+json or application/json) or JSON Patch
+// (application/json-patch+json) of user document. It supports If-Match
+func (h *Handler) PatchUser(c echo.Context) error {
+	var (
+		user    User
+		doc     Document
+		patched []byte
+		err     error
+	)
+
+	user.ID, err = strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		return problem.BadRequest("id not recognized")
+	}
+	mediaType, _, err := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	if err != nil {
+		return ErrPatchMediaType
+	}
+	patch, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return problem.BadRequest("body not recognized")
+	}
+
+	if err = user.Find(h.C.Orm); err != nil {
+		return err
+	}
+	if !ifMatch(c, &user) {
+		return ErrPreconditionFailed
+	}
+	orig, err := json.Marshal(Document{Login: &user.Login, Email: &user.Email, Role: &user.Role})
+	if err != nil {
+		return problem.Internal(err)
+	}
+
+	switch mediaType {
+	case jsonpatch.MIMEMergePatch, echo.MIMEApplicationJSON:
+		patched, err = jsonpatch.MergePatch(orig, patch)
+	case jsonpatch.MIMEJSONPatch:
+		patched, err = jsonpatch.Apply(orig, patch)
+	default:
+		return ErrPatchMediaType
+	}
+	if err != nil {
+		switch err.(type) {
+		case *json.SyntaxError, *json.UnmarshalTypeError:
+			return problem.BadRequest("patch not recognized: " + err.Error())
+		}
+		if err == jsonpatch.ErrTestFailed {
+			return ErrPatchTestFailed
+		}
+		return problem.New(http.StatusUnprocessableEntity, "patch_not_applied", err.Error())
+	}
+
+	// patched document must still be a user document
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&doc); err != nil {
+		return problem.New(http.StatusUnprocessableEntity, "patch_not_applied", "patched document is not a user: "+err.Error())
+	}
+	return h.replace(c, &user, doc.input())
+}
+
+//------------------------------------------------------------------------------
+// input turns document into full replacement, removed fields get their defaults
+func (d *Document) input() Input {
+	input := Input{Role: access.RoleUser}
+	if d.Login != nil {
+		input.Login = *d.Login
+	}
+	if d.Email != nil {
+		input.Email = *d.Email
+	}
+	if d.Role != nil {
+		input.Role = *d.Role
+	}
+	if d.Password != nil {
+		input.Password = *d.Password
+	}
+	return input
+}
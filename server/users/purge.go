@@ -0,0 +1,24 @@
+package users
+
+import (
+	"strconv"
+	"time"
+)
+
+// PurgeDeleted removes users deleted more than retention ago every interval, never returns.
+// Non-positive retention keeps deleted users forever
+func (h *Handler) PurgeDeleted(retention, interval time.Duration) {
+	if retention <= 0 {
+		return
+	}
+	for range time.Tick(interval) {
+		purged, err := PurgeDeleted(h.C.Orm, time.Now().Add(-retention), h.Sessions.PurgeSessions)
+		if err != nil {
+			h.C.Logger.Error("users", "deleted users purge error: "+err.Error())
+			continue
+		}
+		if purged != 0 {
+			h.C.Logger.Info("users", "deleted users purge: "+strconv.FormatInt(purged, 10)+" purged")
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package users
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-xorm/xorm"
+
+	"github.com/nilvxingren/echoxormdemo/server/problem"
+)
+
+// Limits of page size
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+// ErrBadCursor is returned by Query.Find on cursor that can not be decoded
+var ErrBadCursor = problem.New(http.StatusBadRequest, "invalid_cursor", "invalid cursor")
+
+// sortable maps sort keys of API to columns
+var sortable = map[string]string{
+	"id":      "id",
+	"login":   "login",
+	"created": "created",
+	"updated": "updated",
+}
+
+// SortField is a column to sort by
+type SortField struct {
+	Column string
+	Desc   bool
+}
+
+// Query describes a page of users list: filters, order and position
+type Query struct {
+	Limit  int
+	Offset int
+	Cursor string // position after the last user of previous page, overrides Offset
+	Sort   []SortField
+
+	LoginPrefix string
+	Email       string
+	CreatedFrom uint64
+	CreatedTo   uint64
+	UpdatedFrom uint64
+	UpdatedTo   uint64
+}
+
+// ParseQuery reads query from URL parameters:
+// limit, offset, cursor, sort=login,-created, login_prefix, email,
+// created_from, created_to, updated_from, updated_to (unix time, inclusive)
+func ParseQuery(params url.Values) (*Query, error) {
+	var err error
+	q := &Query{Limit: DefaultLimit}
+
+	if v := params.Get("limit"); len(v) != 0 {
+		q.Limit, err = strconv.Atoi(v)
+		if err != nil || q.Limit <= 0 || q.Limit > MaxLimit {
+			return nil, problem.Validation("limit must be in 1.." + strconv.Itoa(MaxLimit))
+		}
+	}
+	if v := params.Get("offset"); len(v) != 0 {
+		q.Offset, err = strconv.Atoi(v)
+		if err != nil || q.Offset < 0 {
+			return nil, problem.Validation("offset must be non-negative integer")
+		}
+	}
+	q.Cursor = params.Get("cursor")
+	if len(q.Cursor) != 0 {
+		q.Offset = 0
+	}
+
+	if v := params.Get("sort"); len(v) != 0 {
+		for _, key := range strings.Split(v, ",") {
+			field := SortField{}
+			if strings.HasPrefix(key, "-") {
+				field.Desc = true
+				key = key[1:]
+			}
+			column, ok := sortable[key]
+			if !ok {
+				return nil, problem.Validation("can not sort by " + key)
+			}
+			field.Column = column
+			q.Sort = append(q.Sort, field)
+		}
+	}
+
+	q.LoginPrefix = params.Get("login_prefix")
+	q.Email = params.Get("email")
+	for name, dst := range map[string]*uint64{
+		"created_from": &q.CreatedFrom,
+		"created_to":   &q.CreatedTo,
+		"updated_from": &q.UpdatedFrom,
+		"updated_to":   &q.UpdatedTo,
+	} {
+		if v := params.Get(name); len(v) != 0 {
+			*dst, err = strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return nil, problem.Validation(name + " must be unix time")
+			}
+		}
+	}
+	return q, nil
+}
+
+// Find returns page of users and total number of users matching filters.
+// Next cursor is empty if there are no more users
+func (q *Query) Find(orm *xorm.Engine) ([]User, int64, string, error) {
+	var (
+		users     []User
+		after     string
+		afterArgs []interface{}
+		err       error
+	)
+
+	cond, args := q.filter()
+	total, err := orm.Where(cond, args...).Count(&User{})
+	if err != nil {
+		return nil, 0, "", problem.DB(err)
+	}
+
+	order := q.order()
+	if len(q.Cursor) != 0 {
+		after, afterArgs, err = q.after(order)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		cond += " AND " + after
+		args = append(args, afterArgs...)
+	}
+
+	session := orm.Where(cond, args...)
+	for _, field := range order {
+		if field.Desc {
+			session = session.Desc(field.Column)
+		} else {
+			session = session.Asc(field.Column)
+		}
+	}
+	err = session.Limit(q.Limit, q.Offset).Find(&users)
+	if err != nil {
+		return nil, 0, "", problem.DB(err)
+	}
+	if users == nil {
+		users = []User{}
+	}
+
+	next := ""
+	if len(users) == q.Limit {
+		next = encodeCursor(&users[len(users)-1], order)
+	}
+	return users, total, next, nil
+}
+
+//------------------------------------------------------------------------------
+// filter builds WHERE condition of filters
+func (q *Query) filter() (string, []interface{}) {
+	conds := []string{"1 = 1"}
+	args := []interface{}{}
+	if len(q.LoginPrefix) != 0 {
+		// backslash would escape closing quote of ESCAPE clause in MySQL
+		escaped := strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`).Replace(q.LoginPrefix)
+		conds = append(conds, "login LIKE ? ESCAPE '!'")
+		args = append(args, escaped+"%")
+	}
+	if len(q.Email) != 0 {
+		conds = append(conds, "email = ?")
+		args = append(args, q.Email)
+	}
+	if q.CreatedFrom != 0 {
+		conds = append(conds, "created >= ?")
+		args = append(args, q.CreatedFrom)
+	}
+	if q.CreatedTo != 0 {
+		conds = append(conds, "created <= ?")
+		args = append(args, q.CreatedTo)
+	}
+	if q.UpdatedFrom != 0 {
+		conds = append(conds, "updated >= ?")
+		args = append(args, q.UpdatedFrom)
+	}
+	if q.UpdatedTo != 0 {
+		conds = append(conds, "updated <= ?")
+		args = append(args, q.UpdatedTo)
+	}
+	return "(" + strings.Join(conds, " AND ") + ")", args
+}
+
+// order returns sort fields ended with unique id, so that order is total
+func (q *Query) order() []SortField {
+	order := make([]SortField, 0, len(q.Sort)+1)
+	for _, field := range q.Sort {
+		if field.Column == "id" {
+			return append(order, field)
+		}
+		order = append(order, field)
+	}
+	return append(order, SortField{Column: "id"})
+}
+
+// after builds condition selecting rows placed after cursor in order:
+// (a > x) OR (a = x AND b > y) OR ...
+func (q *Query) after(order []SortField) (string, []interface{}, error) {
+	values, err := decodeCursor(q.Cursor, order)
+	if err != nil {
+		return "", nil, err
+	}
+	var (
+		ors  []string
+		args []interface{}
+	)
+	for i, field := range order {
+		ands := []string{}
+		for j := 0; j < i; j++ {
+			ands = append(ands, order[j].Column+" = ?")
+			args = append(args, values[j])
+		}
+		op := " > ?"
+		if field.Desc {
+			op = " < ?"
+		}
+		ands = append(ands, field.Column+op)
+		args = append(args, values[i])
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", args, nil
+}
+
+func encodeCursor(u *User, order []SortField) string {
+	values := make([]interface{}, 0, len(order))
+	for _, field := range order {
+		switch field.Column {
+		case "id":
+			values = append(values, u.ID)
+		case "login":
+			values = append(values, u.Login)
+		case "created":
+			values = append(values, u.Created)
+		case "updated":
+			values = append(values, u.Updated)
+		}
+	}
+	data, _ := json.Marshal(values)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string, order []SortField) ([]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	var raw []interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err = decoder.Decode(&raw); err != nil || len(raw) != len(order) {
+		return nil, ErrBadCursor
+	}
+	values := make([]interface{}, len(order))
+	for i, field := range order {
+		if field.Column == "login" {
+			s, ok := raw[i].(string)
+			if !ok {
+				return nil, ErrBadCursor
+			}
+			values[i] = s
+			continue
+		}
+		n, ok := raw[i].(json.Number)
+		if !ok {
+			return nil, ErrBadCursor
+		}
+		values[i], err = strconv.ParseUint(n.String(), 10, 64)
+		if err != nil {
+			return nil, ErrBadCursor
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,96 @@
+package totp_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nilvxingren/echoxormdemo/totp"
+)
+
+// secret of RFC 4226 and RFC 6238 test vectors, ASCII "12345678901234567890"
+const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// Vectors of RFC 6238 Appendix B (SHA-1), codes are truncated to Digits
+func TestCode(t *testing.T) {
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		step := totp.Step(time.Unix(tt.time, 0))
+		code, err := totp.Code(secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := tt.code[len(tt.code)-totp.Digits:]
+		if code != expected {
+			t.Errorf("time %d: got %s, expected %s", tt.time, code, expected)
+		}
+	}
+}
+
+// Vectors of RFC 4226 Appendix D, counter is a time step
+func TestCodeOfCounter(t *testing.T) {
+	codes := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, expected := range codes {
+		code, err := totp.Code(strings.ToLower(secret), int64(counter))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != expected {
+			t.Errorf("counter %d: got %s, expected %s", counter, code, expected)
+		}
+	}
+	if _, err := totp.Code("not base32!", 0); err == nil {
+		t.Error("malformed secret accepted")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	// 1111111109 is at the end of step 37037036, next step begins a second later
+	now := time.Unix(1111111109, 0)
+	step := totp.Step(now)
+	code := func(step int64) string {
+		c, err := totp.Code(secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	tests := []struct {
+		name     string
+		code     string
+		at       time.Time
+		lastStep int64
+		step     int64
+		ok       bool
+	}{
+		{"current step", code(step), now, 0, step, true},
+		{"previous step", code(step - 1), now, 0, step - 1, true},
+		{"next step", code(step + 1), now, 0, step + 1, true},
+		{"two steps before", code(step - 2), now, 0, 0, false},
+		{"two steps after", code(step + 2), now, 0, 0, false},
+		{"previous step a second later", code(step - 1), now.Add(time.Second), 0, 0, false},
+		{"current step a second later", code(step), now.Add(time.Second), 0, step, true},
+		{"code used already", code(step), now, step, 0, false},
+		{"code of step before used one", code(step - 1), now, step - 1, 0, false},
+		{"code after used one", code(step + 1), now, step, step + 1, true},
+		{"short code", code(step)[1:], now, 0, 0, false},
+		{"long code", code(step) + "0", now, 0, 0, false},
+		{"wrong code", "000000", time.Unix(59, 0), 0, 0, false},
+	}
+	for _, tt := range tests {
+		step, ok := totp.Validate(secret, tt.code, tt.at, tt.lastStep)
+		if ok != tt.ok || step != tt.step {
+			t.Errorf("%s: got %d %v, expected %d %v", tt.name, step, ok, tt.step, tt.ok)
+		}
+	}
+}
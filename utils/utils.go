@@ -1,7 +1,11 @@
 package utils
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
 	"encoding/base64"
+	"errors"
 
 	"golang.org/x/crypto/sha3"
 )
@@ -12,3 +16,55 @@ func GetSHA3Hash(data string) string {
 	sha3.ShakeSum256(h, []byte(data))
 	return base64.StdEncoding.EncodeToString(h)
 }
+
+// GetRandomToken returns url-safe string made of size random bytes
+func GetRandomToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Encrypt seals data with AES-GCM, key must be 16, 24 or 32 bytes long.
+// Returns base64 of nonce followed by ciphertext
+func Encrypt(key []byte, data string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(data), nil)), nil
+}
+
+// Decrypt opens data sealed by Encrypt
+func Decrypt(key []byte, sealed string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	b, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	if len(b) < gcm.NonceSize() {
+		return "", errors.New("sealed data too short")
+	}
+	data, err := gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+//------------------------------------------------------------------------------
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,300 @@
+// Package validator checks struct fields against rules listed in `validate` tags.
+// It implements echo.Validator and reports every violated rule of every field at once
+package validator
+
+import (
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TagName is a struct tag rules are listed in, comma separated
+const TagName = "validate"
+
+// Rule checks value against param and returns message of violation, empty if value is valid
+type Rule func(v reflect.Value, param string) string
+
+// FieldError represents violation of one rule by one field
+type FieldError struct {
+	Field   string `json:"field"` // name of field in JSON
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Error implements error interface
+func (e FieldError) Error() string {
+	return e.Field + " " + e.Message
+}
+
+// Errors lists every violation found in struct
+type Errors []FieldError
+
+// Error implements error interface
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i := range e {
+		messages[i] = e[i].Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Validator checks structs by their tags. Rules:
+//
+//	required      value is not empty, other rules are skipped for empty value
+//	omitempty     other rules are skipped for empty value
+//	min=N, max=N  length of string in characters, of slice in elements
+//	oneof=a b c   value is one of words
+//	login, name   letters, digits, '_', '.' and '-', starts with letter or digit
+//	email         bare address, no display name
+//	password      characters of two classes at least: lower, upper, digits, others
+//
+// Rules other than required, min and max are applied to every element of slice
+type Validator struct {
+	mu     sync.Mutex
+	rules  map[string]Rule
+	fields map[reflect.Type][]field
+}
+
+// field is a parsed tag of struct field
+type field struct {
+	index []int
+	name  string
+	rules []ruleRef
+}
+
+type ruleRef struct {
+	name  string
+	param string
+}
+
+// New constructor
+func New() *Validator {
+	v := new(Validator)
+	v.rules = map[string]Rule{
+		"required": required,
+		"min":      minLength,
+		"max":      maxLength,
+		"oneof":    oneOf,
+		"login":    identifier,
+		"name":     identifier,
+		"email":    email,
+		"password": password,
+	}
+	v.fields = make(map[reflect.Type][]field)
+	return v
+}
+
+// Register adds rule or replaces existing one
+func (v *Validator) Register(name string, rule Rule) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.rules[name] = rule
+}
+
+// Validate checks struct (or pointer to struct) i. Returns Errors or nil
+func (v *Validator) Validate(i interface{}) error {
+	value := reflect.Indirect(reflect.ValueOf(i))
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+	var errs Errors
+	for _, f := range v.fieldsOf(value.Type()) {
+		fv := value.FieldByIndex(f.index)
+		empty := isEmpty(fv)
+		for _, r := range f.rules {
+			if r.name == "omitempty" {
+				if empty {
+					break
+				}
+				continue
+			}
+			if message := v.check(fv, r); len(message) != 0 {
+				errs = append(errs, FieldError{Field: f.name, Rule: r.name, Message: message})
+				// other rules are pointless for missing value
+				if r.name == "required" {
+					break
+				}
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+//------------------------------------------------------------------------------
+// check applies rule to value, rules of values are applied to every element of slice
+func (v *Validator) check(value reflect.Value, r ruleRef) string {
+	v.mu.Lock()
+	rule, ok := v.rules[r.name]
+	v.mu.Unlock()
+	if !ok {
+		return "has unknown rule " + r.name
+	}
+	if value.Kind() != reflect.Slice || r.name == "required" || r.name == "min" || r.name == "max" {
+		return rule(value, r.param)
+	}
+	for i := 0; i < value.Len(); i++ {
+		if message := rule(value.Index(i), r.param); len(message) != 0 {
+			return "element " + strconv.Itoa(i) + " " + message
+		}
+	}
+	return ""
+}
+
+// fieldsOf returns parsed tags of struct type, they are parsed once per type
+func (v *Validator) fieldsOf(t reflect.Type) []field {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	fields, ok := v.fields[t]
+	if !ok {
+		fields = parseFields(t)
+		v.fields[t] = fields
+	}
+	return fields
+}
+
+// parseFields parses tags of struct type, fields of embedded structs included
+func parseFields(t reflect.Type) []field {
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			for _, f := range parseFields(sf.Type) {
+				f.index = append([]int{i}, f.index...)
+				fields = append(fields, f)
+			}
+			continue
+		}
+		tag := sf.Tag.Get(TagName)
+		if len(tag) == 0 || tag == "-" {
+			continue
+		}
+		f := field{index: []int{i}, name: jsonName(sf)}
+		for _, part := range strings.Split(tag, ",") {
+			name, param := part, ""
+			if eq := strings.IndexByte(part, '='); eq >= 0 {
+				name, param = part[:eq], part[eq+1:]
+			}
+			f.rules = append(f.rules, ruleRef{name: name, param: param})
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// jsonName returns name of field in JSON, errors are reported by it
+func jsonName(sf reflect.StructField) string {
+	name := strings.Split(sf.Tag.Get("json"), ",")[0]
+	if len(name) == 0 || name == "-" {
+		return sf.Name
+	}
+	return name
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+// length returns length of string in characters, of slice in elements, and unit of length
+func length(v reflect.Value) (int, string, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return utf8.RuneCountInString(v.String()), "characters", true
+	case reflect.Slice, reflect.Map:
+		return v.Len(), "elements", true
+	}
+	return 0, "", false
+}
+
+func required(v reflect.Value, _ string) string {
+	if isEmpty(v) {
+		return "is required"
+	}
+	return ""
+}
+
+func minLength(v reflect.Value, param string) string {
+	n, _ := strconv.Atoi(param)
+	if l, unit, ok := length(v); ok && l < n {
+		return "must be at least " + param + " " + unit + " long"
+	}
+	return ""
+}
+
+func maxLength(v reflect.Value, param string) string {
+	n, _ := strconv.Atoi(param)
+	if l, unit, ok := length(v); ok && l > n {
+		return "must be at most " + param + " " + unit + " long"
+	}
+	return ""
+}
+
+func oneOf(v reflect.Value, param string) string {
+	if v.Kind() != reflect.String {
+		return ""
+	}
+	for _, word := range strings.Fields(param) {
+		if v.String() == word {
+			return ""
+		}
+	}
+	return "must be one of: " + strings.Join(strings.Fields(param), ", ")
+}
+
+func identifier(v reflect.Value, _ string) string {
+	s := v.String()
+	for i, r := range s {
+		if r > unicode.MaxASCII {
+			return "must contain latin letters, digits, '_', '.' and '-' only"
+		}
+		alnum := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if i == 0 && !alnum {
+			return "must start with letter or digit"
+		}
+		if !alnum && r != '_' && r != '.' && r != '-' {
+			return "must contain latin letters, digits, '_', '.' and '-' only"
+		}
+	}
+	return ""
+}
+
+func email(v reflect.Value, _ string) string {
+	s := v.String()
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Address != s {
+		return "must be an email address"
+	}
+	return ""
+}
+
+func password(v reflect.Value, _ string) string {
+	var lower, upper, digit, other int
+	for _, r := range v.String() {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	if lower+upper+digit+other < 2 {
+		return "must mix two kinds of characters at least: lower case, upper case, digits, others"
+	}
+	return ""
+}